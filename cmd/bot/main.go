@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log/slog"
 	"net/http"
 	"os"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/joho/godotenv"
 	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/metrics"
 	"github.com/suspectuso/ton-tracker/internal/notifier"
 	"github.com/suspectuso/ton-tracker/internal/storage"
 	"github.com/suspectuso/ton-tracker/internal/telegram"
@@ -19,6 +21,9 @@ import (
 )
 
 func main() {
+	migrateOnly := flag.Bool("migrate", false, "apply database migrations and exit")
+	flag.Parse()
+
 	// Setup logger
 	log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
@@ -33,23 +38,43 @@ func main() {
 	// Load config
 	cfg := config.Load()
 
+	if *migrateOnly {
+		if err := runMigrations(cfg, log); err != nil {
+			log.Error("migrate", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if cfg.BotToken == "" {
 		log.Error("BOT_TOKEN is required")
 		os.Exit(1)
 	}
 
 	// Initialize storage
-	store, err := storage.New(cfg.DBPath)
+	store, err := storage.New(cfg.DBDriver, dataSource(cfg))
 	if err != nil {
 		log.Error("init storage", "error", err)
 		os.Exit(1)
 	}
 	defer store.Close()
-	log.Info("storage initialized", "path", cfg.DBPath)
+	log.Info("storage initialized", "driver", cfg.DBDriver)
 
 	// Initialize TonAPI client
-	tonAPI := tonapi.NewClient(cfg.TonAPIBaseURL, cfg.TonAPIKey)
-	log.Info("tonapi client initialized", "base_url", cfg.TonAPIBaseURL)
+	tonAPI := tonapi.NewClient(cfg.TonAPIBaseURL, cfg.TonAPIKey, cfg.TonAPIRPS)
+	log.Info("tonapi client initialized", "base_url", cfg.TonAPIBaseURL, "rps", cfg.TonAPIRPS)
+
+	// Event history for polling and catch-up, with liteservers as a fallback
+	var events tonapi.EventSource = tonAPI
+	if cfg.LiteServers != "" {
+		lite, err := tonapi.NewLiteClient(cfg.LiteServers)
+		if err != nil {
+			log.Error("init lite client", "error", err)
+		} else {
+			events = tonapi.NewFallbackSource(tonAPI, lite, log)
+			log.Info("liteserver fallback enabled")
+		}
+	}
 
 	// Initialize telegram bot
 	bot, err := telegram.New(cfg, store, tonAPI, log)
@@ -59,18 +84,24 @@ func main() {
 	}
 	log.Info("telegram bot initialized")
 
-	// Initialize notifier
-	notify := notifier.New(cfg, store, bot, log)
+	// Initialize notification outbox and notifier
+	outbox := notifier.NewOutbox(cfg, store, bot, log)
+	notify := notifier.New(cfg, store, tonAPI, outbox, log)
 
 	// Initialize webhook manager
-	webhookManager := webhook.NewManager(store, tonAPI, cfg.WebhookEndpoint, log)
+	webhookManager := webhook.NewManager(store, tonAPI, cfg.WebhookEndpoint, cfg.WebhookSecret, log)
 
 	// Create context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Initialize webhook
-	if cfg.WebhookEndpoint != "" {
+	log.Info("tracker mode", "mode", cfg.TrackerMode)
+	if cfg.TrackerMode == "webhook" && cfg.WebhookSecret == "" {
+		log.Error("WEBHOOK_SECRET is required in webhook mode")
+		os.Exit(1)
+	}
+	if cfg.TrackerMode == "webhook" {
 		if err := webhookManager.Init(ctx); err != nil {
 			log.Error("init webhook", "error", err)
 		} else {
@@ -78,23 +109,50 @@ func main() {
 		}
 	}
 
+	// Snapshot account cursors before live events start moving them
+	cursors, err := store.ListAccountCursors()
+	if err != nil {
+		log.Error("list account cursors", "error", err)
+	}
+
 	// Start webhook server
-	webhookServer := webhook.NewServer(store, tonAPI, notify.HandleEvent, log)
+	webhookServer := webhook.NewServer(cfg, store, tonAPI, notify.HandleEvent, log)
+	serverDone := make(chan struct{})
 	go func() {
+		defer close(serverDone)
 		if err := webhookServer.Start(ctx, cfg.WebhookPort); err != nil && err != http.ErrServerClosed {
 			log.Error("webhook server", "error", err)
 		}
 	}()
 
-	// Start webhook sync loop
-	go webhookManager.SyncLoop(ctx, 30*time.Second)
+	metrics.RegisterQueue("telegram", bot.QueueDepth)
+	metrics.RegisterQueue("webhook", webhookServer.QueueDepth)
+
+	// Start notification delivery (resumes messages pending from a previous run)
+	go outbox.Start(ctx, 5*time.Second)
+
+	// Start the tracker: webhook subscriptions, SSE streams or polling
+	switch cfg.TrackerMode {
+	case "webhook":
+		go webhookManager.SyncLoop(ctx, 5*time.Minute)
+	case "sse":
+		streamTracker := webhook.NewStreamTracker(cfg, store, tonAPI, events, webhookServer, log)
+		go streamTracker.Start(ctx, 5*time.Minute)
+	case "poll":
+		poller := webhook.NewPoller(cfg, store, events, webhookServer, log)
+		go poller.Start(ctx)
+	default:
+		log.Error("unknown TRACKER_MODE, expected webhook, sse or poll", "mode", cfg.TrackerMode)
+		os.Exit(1)
+	}
 
 	// Start premium checker
-	premiumChecker := notifier.NewPremiumChecker(cfg, store, tonAPI, bot, log)
+	premiumChecker := notifier.NewPremiumChecker(cfg, store, tonAPI, outbox, log)
+	bot.SetPremiumActivator(premiumChecker.Grant)
 	go premiumChecker.Start(ctx, 10*time.Second)
 
-	// Seed all wallets (mark existing events as processed)
-	go seedAllWallets(ctx, store, tonAPI, log)
+	// Deliver events missed while the bot was down
+	go notify.Backfill(ctx, cursors)
 
 	// Handle shutdown signals
 	sigCh := make(chan os.Signal, 1)
@@ -109,40 +167,38 @@ func main() {
 	// Start bot polling
 	log.Info("starting bot polling...")
 	bot.Start(ctx)
+
+	// Wait for queued webhooks before closing storage
+	<-serverDone
 }
 
-// seedAllWallets marks all existing events as processed to avoid sending old notifications
-func seedAllWallets(ctx context.Context, store *storage.Storage, tonAPI *tonapi.Client, log *slog.Logger) {
-	wallets, err := store.GetAllWallets()
+// runMigrations applies pending database migrations without starting the bot
+func runMigrations(cfg *config.Config, log *slog.Logger) error {
+	store, err := storage.Open(cfg.DBDriver, dataSource(cfg))
 	if err != nil {
-		log.Error("get all wallets for seeding", "error", err)
-		return
+		return err
 	}
+	defer store.Close()
 
-	if len(wallets) == 0 {
-		log.Info("no wallets to seed")
-		return
+	applied, err := store.Migrate()
+	if err != nil {
+		return err
 	}
 
-	log.Info("seeding wallets", "count", len(wallets))
+	version, err := store.SchemaVersion()
+	if err != nil {
+		return err
+	}
 
-	totalSeeded := 0
-	for _, w := range wallets {
-		events, err := tonAPI.GetEvents(ctx, w.AddressRaw, 5)
-		if err != nil {
-			log.Warn("fetch events for seeding", "wallet_id", w.ID, "error", err)
-			continue
-		}
+	log.Info("migrations complete", "applied", applied, "version", version, "driver", cfg.DBDriver)
+	return nil
+}
 
-		for _, ev := range events {
-			if ev.EventID != "" {
-				isNew, _ := store.MarkEventProcessed(w.ID, ev.EventID)
-				if isNew {
-					totalSeeded++
-				}
-			}
-		}
+// dataSource returns the connection string for the configured database driver
+func dataSource(cfg *config.Config) string {
+	switch cfg.DBDriver {
+	case "postgres", "postgresql":
+		return cfg.DatabaseURL
 	}
-
-	log.Info("seeding complete", "events_marked", totalSeeded)
+	return cfg.DBPath
 }
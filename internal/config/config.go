@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -14,13 +15,30 @@ type Config struct {
 	// TonAPI
 	TonAPIKey     string
 	TonAPIBaseURL string
+	TonAPIRPS     float64 // requests per second allowed by the API key tier
 
 	// Webhook
-	WebhookEndpoint string
-	WebhookPort     int
+	WebhookEndpoint  string
+	WebhookPort      int
+	WebhookSecret    string // shared secret TonAPI must present, required in webhook mode
+	WebhookWorkers   int
+	WebhookQueueSize int
+
+	// Tracker mode: "webhook", "sse" or "poll"; defaults to webhook
+	// when WebhookEndpoint is set and to polling otherwise
+	TrackerMode string
+
+	// Polling
+	PollMinInterval time.Duration
+	PollMaxInterval time.Duration
+
+	// Liteservers used when TonAPI is unavailable: "mainnet" or "ip:port:key,..."
+	LiteServers string
 
 	// Database
-	DBPath string
+	DBDriver    string // "sqlite" or "postgres"
+	DBPath      string
+	DatabaseURL string
 
 	// Limits
 	MaxWalletsPerUser        int
@@ -29,11 +47,23 @@ type Config struct {
 	VIPMaxWalletsPerUser     int
 
 	// Premium
-	PremiumPriceTON   float64
-	ServiceWalletAddr string
+	PremiumPriceTON     float64 // monthly plan
+	PremiumPlans        []PremiumPlan
+	PremiumJettons      []PremiumJetton // jettons accepted besides TON
+	PremiumReminderDays int             // days before expiry to remind about renewal
+	PremiumInvoiceTTL   time.Duration   // how long an invoice amount stays reserved
+	ServiceWalletAddr   string
 
 	// Filters
 	MinTransferTON float64
+
+	// Notifications
+	OutboxMaxAttempts  int
+	TelegramGlobalRate float64 // messages per second across all chats
+	TelegramChatRate   float64 // messages per second to a single chat
+
+	// Events missed during downtime are summarized when there are more than this (0 = never)
+	BackfillSummaryThreshold int
 }
 
 func Load() *Config {
@@ -45,13 +75,25 @@ func Load() *Config {
 		// TonAPI
 		TonAPIKey:     getEnv("TONAPI_API_KEY", ""),
 		TonAPIBaseURL: strings.TrimSuffix(getEnv("TONAPI_BASE_URL", "https://tonapi.io/v2"), "/"),
+		TonAPIRPS:     getEnvFloat("TONAPI_RPS", 1),
 
 		// Webhook
-		WebhookEndpoint: getEnv("WEBHOOK_ENDPOINT", ""),
-		WebhookPort:     getEnvInt("WEBHOOK_PORT", 8080),
+		WebhookEndpoint:  getEnv("WEBHOOK_ENDPOINT", ""),
+		WebhookPort:      getEnvInt("WEBHOOK_PORT", 8080),
+		WebhookSecret:    getEnv("WEBHOOK_SECRET", ""),
+		WebhookWorkers:   getEnvInt("WEBHOOK_WORKERS", 8),
+		WebhookQueueSize: getEnvInt("WEBHOOK_QUEUE_SIZE", 1000),
+
+		// Tracking
+		TrackerMode:     strings.ToLower(getEnv("TRACKER_MODE", "")),
+		PollMinInterval: getEnvDuration("POLL_MIN_INTERVAL", 10*time.Second),
+		PollMaxInterval: getEnvDuration("POLL_MAX_INTERVAL", 5*time.Minute),
+		LiteServers:     getEnv("LITE_SERVERS", ""),
 
 		// Database
-		DBPath: getEnv("DB_PATH", "./tracker.db"),
+		DBDriver:    getEnv("DB_DRIVER", "sqlite"),
+		DBPath:      getEnv("DB_PATH", "./tracker.db"),
+		DatabaseURL: getEnv("DATABASE_URL", ""),
 
 		// Limits
 		MaxWalletsPerUser:        getEnvInt("MAX_WALLETS_PER_USER", 3),
@@ -59,11 +101,54 @@ func Load() *Config {
 		VIPMaxWalletsPerUser:     getEnvInt("VIP_MAX_WALLETS_PER_USER", 10),
 
 		// Premium
-		PremiumPriceTON:   getEnvFloat("PREMIUM_PRICE_TON", 5.0),
-		ServiceWalletAddr: getEnv("SERVICE_WALLET_ADDR", ""),
+		PremiumPriceTON:     getEnvFloat("PREMIUM_PRICE_TON", 5.0),
+		PremiumReminderDays: getEnvInt("PREMIUM_REMINDER_DAYS", 3),
+		PremiumInvoiceTTL:   getEnvDuration("PREMIUM_INVOICE_TTL", time.Hour),
+		ServiceWalletAddr:   getEnv("SERVICE_WALLET_ADDR", ""),
 
 		// Filters
 		MinTransferTON: getEnvFloat("MIN_TRANSFER_TON", 0),
+
+		// Notifications
+		OutboxMaxAttempts:  getEnvInt("OUTBOX_MAX_ATTEMPTS", 10),
+		TelegramGlobalRate: getEnvFloat("TELEGRAM_GLOBAL_RATE", 30),
+		TelegramChatRate:   getEnvFloat("TELEGRAM_CHAT_RATE", 1),
+
+		BackfillSummaryThreshold: getEnvInt("BACKFILL_SUMMARY_THRESHOLD", 5),
+	}
+
+	// Premium plans, a non-positive TON price disables a plan and a
+	// non-positive Stars price disables paying for it with Telegram Stars
+	const day = 24 * time.Hour
+	for _, plan := range []PremiumPlan{
+		{
+			ID: "month", Title: "1 месяц", Period: 30 * day,
+			PriceTON:   cfg.PremiumPriceTON,
+			PriceStars: getEnvInt("PREMIUM_PRICE_STARS", 0),
+		},
+		{
+			ID: "quarter", Title: "3 месяца", Period: 90 * day,
+			PriceTON:   getEnvFloat("PREMIUM_PRICE_QUARTER_TON", 13),
+			PriceStars: getEnvInt("PREMIUM_PRICE_QUARTER_STARS", 0),
+		},
+		{
+			ID: "year", Title: "12 месяцев", Period: 365 * day,
+			PriceTON:   getEnvFloat("PREMIUM_PRICE_YEAR_TON", 45),
+			PriceStars: getEnvInt("PREMIUM_PRICE_YEAR_STARS", 0),
+		},
+	} {
+		if plan.PriceTON > 0 {
+			cfg.PremiumPlans = append(cfg.PremiumPlans, plan)
+		}
+	}
+
+	cfg.PremiumJettons = parsePremiumJettons(getEnv("PREMIUM_JETTONS", ""))
+
+	if cfg.TrackerMode == "" {
+		cfg.TrackerMode = "poll"
+		if cfg.WebhookEndpoint != "" {
+			cfg.TrackerMode = "webhook"
+		}
 	}
 
 	// Parse VIP user IDs
@@ -79,6 +164,75 @@ func Load() *Config {
 	return cfg
 }
 
+// PremiumPlan is a premium period that can be bought
+type PremiumPlan struct {
+	ID         string
+	Title      string
+	Period     time.Duration
+	PriceTON   float64
+	PriceStars int // Telegram Stars (XTR), 0 if not sold for Stars
+}
+
+// PremiumPlanByID returns the plan with the given ID, or nil
+func (c *Config) PremiumPlanByID(id string) *PremiumPlan {
+	for i := range c.PremiumPlans {
+		if c.PremiumPlans[i].ID == id {
+			return &c.PremiumPlans[i]
+		}
+	}
+	return nil
+}
+
+// PremiumJetton is a jetton accepted for premium, with a price per plan ID
+type PremiumJetton struct {
+	Symbol string
+	Master string // jetton master address in any format
+	Prices map[string]float64
+}
+
+// PremiumJettonBySymbol returns the accepted jetton with the given symbol, or nil
+func (c *Config) PremiumJettonBySymbol(symbol string) *PremiumJetton {
+	for i := range c.PremiumJettons {
+		if strings.EqualFold(c.PremiumJettons[i].Symbol, symbol) {
+			return &c.PremiumJettons[i]
+		}
+	}
+	return nil
+}
+
+// parsePremiumJettons parses "SYMBOL,MASTER,MONTH[,QUARTER[,YEAR]];..." where
+// prices are in jetton units and a missing or zero price disables the plan
+func parsePremiumJettons(s string) []PremiumJetton {
+	planIDs := []string{"month", "quarter", "year"}
+
+	var jettons []PremiumJetton
+	for _, entry := range strings.Split(s, ";") {
+		fields := strings.Split(strings.TrimSpace(entry), ",")
+		if len(fields) < 3 {
+			continue
+		}
+
+		j := PremiumJetton{
+			Symbol: strings.TrimSpace(fields[0]),
+			Master: strings.TrimSpace(fields[1]),
+			Prices: make(map[string]float64),
+		}
+		for i, field := range fields[2:] {
+			if i >= len(planIDs) {
+				break
+			}
+			if price, err := strconv.ParseFloat(strings.TrimSpace(field), 64); err == nil && price > 0 {
+				j.Prices[planIDs[i]] = price
+			}
+		}
+
+		if j.Symbol != "" && j.Master != "" && len(j.Prices) > 0 {
+			jettons = append(jettons, j)
+		}
+	}
+	return jettons
+}
+
 func getEnv(key, defaultVal string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
@@ -95,6 +249,15 @@ func getEnvInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	if val := os.Getenv(key); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+	}
+	return defaultVal
+}
+
 func getEnvFloat(key string, defaultVal float64) float64 {
 	if val := os.Getenv(key); val != "" {
 		if f, err := strconv.ParseFloat(val, 64); err == nil {
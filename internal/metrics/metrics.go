@@ -0,0 +1,97 @@
+// Package metrics defines the Prometheus metrics exported on /metrics
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ton_tracker"
+
+var (
+	WebhooksReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhooks_received_total",
+		Help:      "Webhook calls received from TonAPI.",
+	})
+
+	WebhooksRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhooks_rejected_total",
+		Help:      "Webhook calls rejected, by reason (unauthorized, invalid, queue_full).",
+	}, []string{"reason"})
+
+	EventActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_actions_total",
+		Help:      "Actions of processed events, by TonAPI action type.",
+	}, []string{"type"})
+
+	NotificationsSent = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_sent_total",
+		Help:      "Notifications delivered to Telegram.",
+	})
+
+	NotificationsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_failed_total",
+		Help:      "Failed notification deliveries, by outcome (rate_limited, retry, dead).",
+	}, []string{"outcome"})
+
+	TonAPIRequests = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tonapi_request_duration_seconds",
+		Help:      "TonAPI request latency, by HTTP method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "status"})
+
+	Subscriptions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webhook_subscriptions",
+		Help:      "Accounts subscribed to the TonAPI webhook.",
+	})
+
+	WalletChangesDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "wallet_changes_dropped_total",
+		Help:      "Wallet changes dropped for a slow reader and replaced by a full resync.",
+	})
+
+	PremiumActivations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "premium_activations_total",
+		Help:      "Premium subscriptions activated.",
+	})
+
+	TelegramSends = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "telegram_send_duration_seconds",
+		Help:      "Telegram sendMessage latency, by result (ok, error).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+)
+
+// RegisterQueue exports the depth of an in-memory queue as a gauge
+func RegisterQueue(name string, depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "queue_depth",
+		Help:        "Items waiting in an in-memory queue.",
+		ConstLabels: prometheus.Labels{"queue": name},
+	}, func() float64 { return float64(depth()) })
+}
+
+// Since returns the seconds elapsed since start, for histograms
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
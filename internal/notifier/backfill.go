@@ -0,0 +1,174 @@
+package notifier
+
+import (
+	"context"
+
+	"github.com/suspectuso/ton-tracker/internal/storage"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+// Backfill delivers events that happened while the bot was down.
+// cursors is a snapshot of the account cursors taken before live events
+// started moving them. Addresses without a cursor haven't had an event since
+// their wallets were added, so everything after that is delivered.
+func (n *Notifier) Backfill(ctx context.Context, cursors map[string]int64) {
+	wallets, err := n.storage.GetAllWallets()
+	if err != nil {
+		n.log.Error("get all wallets for backfill", "error", err)
+		return
+	}
+
+	if len(wallets) == 0 {
+		n.log.Info("no wallets to backfill")
+		return
+	}
+
+	byAddr := make(map[string][]storage.Wallet)
+	for _, w := range wallets {
+		byAddr[w.AddressRaw] = append(byAddr[w.AddressRaw], w)
+	}
+
+	n.log.Info("backfilling wallets", "wallets", len(wallets), "addresses", len(byAddr))
+
+	delivered := 0
+	for addr, addrWallets := range byAddr {
+		if ctx.Err() != nil {
+			return
+		}
+		delivered += n.backfillAddress(ctx, addr, cursors[addr], addrWallets)
+	}
+
+	n.log.Info("backfill complete", "events_delivered", delivered)
+}
+
+// backfillAddress notifies wallets about events newer than cursor, or about
+// events since the wallets were added when there is no cursor yet.
+// Returns the number of delivered events.
+func (n *Notifier) backfillAddress(ctx context.Context, addr string, cursor int64, wallets []storage.Wallet) int {
+	var notBefore int64
+	if cursor == 0 {
+		notBefore = wallets[0].CreatedAt.Unix()
+		for _, w := range wallets[1:] {
+			notBefore = min(notBefore, w.CreatedAt.Unix())
+		}
+	}
+
+	events, boundaryLt, err := n.eventsSince(ctx, addr, cursor, notBefore)
+	if err != nil {
+		n.log.Warn("fetch missed events", "address", addr, "error", err)
+		return 0
+	}
+
+	events = completedEvents(events)
+	if len(events) == 0 {
+		// Nothing new, but the first cursor saves walking back again next time
+		if cursor == 0 {
+			n.advanceCursor(addr, boundaryLt)
+		}
+		return 0
+	}
+
+	delivered := 0
+	for _, w := range wallets {
+		wallet := w
+
+		var missed []notification
+		for i := range events {
+			ev := &events[i]
+
+			// Don't notify about events from before the wallet was added
+			if ev.EventID == "" || ev.Timestamp < wallet.CreatedAt.Unix() {
+				continue
+			}
+
+			isNew, err := n.storage.MarkEventProcessed(wallet.ID, ev.EventID)
+			if err != nil {
+				n.log.Error("mark event processed", "error", err)
+				continue
+			}
+			if !isNew {
+				continue
+			}
+
+			missed = append(missed, n.buildNotifications(ctx, &wallet, ev)...)
+			delivered++
+		}
+
+		n.deliverMissed(&wallet, missed)
+	}
+
+	n.advanceCursor(addr, events[len(events)-1].Lt)
+	return delivered
+}
+
+// eventsSince returns the account events newer than cursor and not older
+// than the notBefore timestamp, oldest first. It walks back to the cursor so
+// no event is skipped, long downtimes are kept readable by the summary
+// threshold in deliverMissed instead. boundaryLt is the lt of the newest
+// event older than notBefore, where the walk stopped, or 0.
+func (n *Notifier) eventsSince(ctx context.Context, addr string, cursor, notBefore int64) (events []tonapi.Event, boundaryLt int64, err error) {
+	err = n.tonAPI.IterateEvents(ctx, addr, cursor, 0, func(ev *tonapi.Event) bool {
+		if ev.Timestamp < notBefore {
+			if !ev.InProgress {
+				boundaryLt = ev.Lt
+			}
+			return false
+		}
+		events = append(events, *ev)
+		return true
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reverseEvents(events)
+	return events, boundaryLt, nil
+}
+
+// completedEvents returns the chronological events before the first one
+// still in progress, which may lack actions and is left for a later pass
+func completedEvents(events []tonapi.Event) []tonapi.Event {
+	for i, ev := range events {
+		if ev.InProgress {
+			return events[:i]
+		}
+	}
+	return events
+}
+
+// reverseEvents turns a newest-first page into chronological order
+func reverseEvents(events []tonapi.Event) {
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+}
+
+// deliverMissed queues missed notifications, or a single summary when there are many
+func (n *Notifier) deliverMissed(wallet *storage.Wallet, missed []notification) {
+	if len(missed) == 0 {
+		return
+	}
+
+	threshold := n.cfg.BackfillSummaryThreshold
+	if threshold > 0 && len(missed) > threshold {
+		if err := n.outbox.Enqueue(wallet.UserID, n.formatAwaySummary(wallet, missed)); err != nil {
+			n.log.Error("queue backfill summary", "error", err)
+		}
+		return
+	}
+
+	for _, msg := range missed {
+		if err := n.outbox.Enqueue(wallet.UserID, msg.Text); err != nil {
+			n.log.Error("queue notification", "category", msg.Category, "error", err)
+		}
+	}
+}
+
+func (n *Notifier) advanceCursor(addr string, lt int64) {
+	if lt <= 0 {
+		return
+	}
+	if err := n.storage.AdvanceAccountCursor(addr, lt); err != nil {
+		n.log.Error("advance account cursor", "address", addr, "error", err)
+	}
+}
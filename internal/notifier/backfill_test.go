@@ -0,0 +1,170 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/storage"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+// eventsServer serves account events newest first, paged like TonAPI
+func eventsServer(t *testing.T, events []tonapi.Event) *tonapi.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		beforeLt, _ := strconv.ParseInt(r.URL.Query().Get("before_lt"), 10, 64)
+
+		var resp tonapi.EventsResponse
+		for _, ev := range events {
+			if beforeLt > 0 && ev.Lt >= beforeLt {
+				continue
+			}
+			if len(resp.Events) == limit {
+				resp.NextFrom = resp.Events[len(resp.Events)-1].Lt
+				break
+			}
+			resp.Events = append(resp.Events, ev)
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	return tonapi.NewClient(srv.URL, "", 1000)
+}
+
+// incomingEvents returns count TON transfers to the test wallet with lt
+// from first to first+count-1, newest first
+func incomingEvents(first int64, count int, ts int64) []tonapi.Event {
+	events := make([]tonapi.Event, 0, count)
+	for lt := first + int64(count) - 1; lt >= first; lt-- {
+		events = append(events, tonapi.Event{
+			EventID:   "ev" + strconv.FormatInt(lt, 10),
+			Lt:        lt,
+			Timestamp: ts,
+			Actions: []tonapi.Action{{
+				Type: "TonTransfer",
+				TonTransfer: &tonapi.TonTransfer{
+					Sender:    tonapi.Account{Address: testOtherRaw},
+					Recipient: tonapi.Account{Address: testWalletRaw},
+					Amount:    1_000_000_000,
+				},
+			}},
+		})
+	}
+	return events
+}
+
+func TestBackfillAddress(t *testing.T) {
+	const cursor = 1000
+
+	tests := []struct {
+		name         string
+		noCursor     bool // address never had an event since the wallet was added
+		missed       int
+		inProgressAt int // index in the newest-first list, -1 for none
+		threshold    int
+		wantMessages int
+		wantCursor   int64
+	}{
+		{
+			name:         "more events than one page",
+			missed:       250,
+			inProgressAt: -1,
+			wantMessages: 250,
+			wantCursor:   cursor + 250,
+		},
+		{
+			name:         "summary caps messages, not events",
+			missed:       1500,
+			inProgressAt: -1,
+			threshold:    5,
+			wantMessages: 1,
+			wantCursor:   cursor + 1500,
+		},
+		{
+			name:         "stops before an event in progress",
+			missed:       10,
+			inProgressAt: 3,
+			wantMessages: 6,
+			wantCursor:   cursor + 6,
+		},
+		{
+			name:         "no cursor delivers events since the wallet was added",
+			noCursor:     true,
+			missed:       10,
+			inProgressAt: -1,
+			wantMessages: 10,
+			wantCursor:   cursor + 10,
+		},
+		{
+			name:         "no cursor and no new events sets the first cursor",
+			noCursor:     true,
+			inProgressAt: -1,
+			wantMessages: 0,
+			wantCursor:   cursor,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := storage.New("sqlite", filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			wallet, err := store.AddWallet(1, "main", testWalletRaw, tonapi.RawToFriendly(testWalletRaw), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			events := incomingEvents(cursor+1, tt.missed, time.Now().Unix()+60)
+			if tt.inProgressAt >= 0 {
+				events[tt.inProgressAt].InProgress = true
+			}
+			// Events up to the cursor are already processed, without a cursor
+			// they are from before the wallet was added; neither is delivered
+			olderTs, walletCursor := time.Now().Unix()+60, int64(cursor)
+			if tt.noCursor {
+				olderTs, walletCursor = time.Now().Unix()-3600, 0
+			}
+			events = append(events, incomingEvents(cursor-4, 5, olderTs)...)
+
+			cfg := &config.Config{BackfillSummaryThreshold: tt.threshold, OutboxMaxAttempts: 1}
+			log := slog.New(slog.NewTextHandler(io.Discard, nil))
+			n := New(cfg, store, eventsServer(t, events), NewOutbox(cfg, store, nil, log), log)
+
+			n.backfillAddress(context.Background(), testWalletRaw, walletCursor, []storage.Wallet{*wallet})
+
+			queued, err := store.ClaimDueNotifications(10_000, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(queued) != tt.wantMessages {
+				t.Errorf("queued %d messages, want %d", len(queued), tt.wantMessages)
+			}
+
+			// The oldest missed event is handled even when there are many
+			oldest := "ev" + strconv.Itoa(cursor+1)
+			if isNew, _ := store.MarkEventProcessed(wallet.ID, oldest); isNew && tt.missed > 0 {
+				t.Errorf("oldest missed event %s was skipped", oldest)
+			}
+
+			got, err := store.GetAccountCursor(testWalletRaw)
+			if err != nil || got != tt.wantCursor {
+				t.Errorf("cursor = %d (%v), want %d", got, err, tt.wantCursor)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package notifier
+
+import (
+	"fmt"
+	"html"
+	"math/big"
+	"strings"
+
+	"github.com/suspectuso/ton-tracker/internal/storage"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+func (n *Notifier) formatSwapMessage(wallet *storage.Wallet, swap Swap) string {
+	var emoji, sideWord string
+	switch swap.Side {
+	case "buy":
+		emoji = "✅"
+		sideWord = "BUY"
+	case "sell":
+		emoji = "🔻"
+		sideWord = "SELL"
+	default:
+		emoji = "🔁"
+		sideWord = "SWAP"
+	}
+
+	// Format DEX name nicely
+	dexDisplay := formatDex(swap.Dex)
+
+	// Wallet link
+	nameLink := fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>",
+		wallet.AddressDisplay, html.EscapeString(wallet.Name))
+
+	// Format amounts, jetton symbols are set by the jetton deployer
+	var pairLine string
+	if swap.Side == "buy" {
+		pairLine = fmt.Sprintf("%s TON 🔄 %s %s",
+			formatAmount(swap.FromAmount), formatAmount(swap.ToAmount), html.EscapeString(swap.ToSymbol))
+	} else {
+		pairLine = fmt.Sprintf("%s %s 🔄 %s TON",
+			formatAmount(swap.FromAmount), html.EscapeString(swap.FromSymbol), formatAmount(swap.ToAmount))
+	}
+
+	// Token address
+	jettonAddr := ""
+	if swap.JettonMaster != "" {
+		friendly := tonapi.RawToFriendly(swap.JettonMaster)
+		jettonAddr = fmt.Sprintf("\n\n<code>%s</code>", friendly)
+	}
+
+	return fmt.Sprintf(
+		"%s <b>%s by %s</b>\n"+
+			"<i>via %s</i>\n\n"+
+			"%s%s",
+		emoji, sideWord, nameLink,
+		dexDisplay,
+		pairLine, jettonAddr,
+	)
+}
+
+func (n *Notifier) formatTransferMessage(wallet *storage.Wallet, tr Transfer) string {
+	var emoji, sign string
+	if tr.Direction == "in" {
+		emoji = "🟩"
+		sign = "+"
+	} else {
+		emoji = "🟥"
+		sign = "-"
+	}
+
+	lines := []string{
+		"<b>🔔 Transfer detected</b>",
+		"",
+		fmt.Sprintf("%s%s TON %s", sign, formatAmount(tonapi.TONAmount(tr.AmountNano)), emoji),
+		"",
+		fmt.Sprintf("%s → %s", accountLink(wallet, tr.Sender), accountLink(wallet, tr.Recipient)),
+	}
+
+	if tr.Comment != "" {
+		lines = append(lines, "", fmt.Sprintf("💬 Comment: <code>%s</code>", html.EscapeString(tr.Comment)))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (n *Notifier) formatJettonTransferMessage(wallet *storage.Wallet, jt JettonTransfer) string {
+	var emoji, sign string
+	if jt.Direction == "in" {
+		emoji = "🟩"
+		sign = "+"
+	} else {
+		emoji = "🟥"
+		sign = "-"
+	}
+
+	// Symbols and comments are set by whoever deploys the jetton or sends the transfer
+	symbol := html.EscapeString(jt.Symbol)
+	if symbol == "" {
+		symbol = "jettons"
+	}
+
+	lines := []string{
+		"<b>🔔 Jetton transfer detected</b>",
+		"",
+		fmt.Sprintf("%s%s %s %s", sign, formatAmount(jt.Amount), symbol, emoji),
+		"",
+		fmt.Sprintf("%s → %s", accountLink(wallet, jt.Sender), accountLink(wallet, jt.Recipient)),
+	}
+
+	if jt.Comment != "" {
+		lines = append(lines, "", fmt.Sprintf("💬 Comment: <code>%s</code>", html.EscapeString(jt.Comment)))
+	}
+
+	if jt.JettonMaster != "" {
+		lines = append(lines, "", fmt.Sprintf("<code>%s</code>", tonapi.RawToFriendly(jt.JettonMaster)))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (n *Notifier) formatNftMessage(wallet *storage.Wallet, nft Nft) string {
+	friendly := tonapi.RawToFriendly(nft.ItemAddress)
+
+	// Item, collection and marketplace names come from NFT metadata anyone can set
+	itemName := html.EscapeString(nft.ItemName)
+	if itemName == "" {
+		itemName = tonapi.ShortAddr(friendly, 4)
+	}
+	itemLink := fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>", friendly, itemName)
+
+	var lines []string
+	if nft.Kind == "purchase" {
+		emoji, sideWord := "✅", "BUY"
+		if nft.Direction == "out" {
+			emoji, sideWord = "🔻", "SELL"
+		}
+
+		nameLink := fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>",
+			wallet.AddressDisplay, html.EscapeString(wallet.Name))
+
+		lines = []string{
+			fmt.Sprintf("%s <b>NFT %s by %s</b>", emoji, sideWord, nameLink),
+			fmt.Sprintf("<i>via %s</i>", html.EscapeString(nft.Marketplace)),
+			"",
+			"🖼 " + itemLink,
+		}
+		if nft.CollectionName != "" {
+			lines = append(lines, fmt.Sprintf("Collection: <b>%s</b>", html.EscapeString(nft.CollectionName)))
+		}
+		lines = append(lines,
+			fmt.Sprintf("💎 Price: <b>%s %s</b>", formatAmount(nft.Price), html.EscapeString(nft.PriceSymbol)),
+			"",
+			fmt.Sprintf("%s → %s", accountLink(wallet, nft.Sender), accountLink(wallet, nft.Recipient)),
+		)
+	} else {
+		emoji := "🟩"
+		if nft.Direction == "out" {
+			emoji = "🟥"
+		}
+
+		lines = []string{
+			"<b>🔔 NFT transfer detected</b>",
+			"",
+			fmt.Sprintf("🖼 %s %s", itemLink, emoji),
+		}
+		if nft.CollectionName != "" {
+			lines = append(lines, fmt.Sprintf("Collection: <b>%s</b>", html.EscapeString(nft.CollectionName)))
+		}
+		lines = append(lines,
+			"",
+			fmt.Sprintf("%s → %s", accountLink(wallet, nft.Sender), accountLink(wallet, nft.Recipient)),
+		)
+		if nft.Comment != "" {
+			lines = append(lines, "", fmt.Sprintf("💬 Comment: <code>%s</code>", html.EscapeString(nft.Comment)))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (n *Notifier) formatStakeMessage(wallet *storage.Wallet, st Stake) string {
+	var emoji, title string
+	switch st.Kind {
+	case "deposit":
+		emoji, title = "🔒", "STAKE"
+	case "withdraw":
+		emoji, title = "🔓", "UNSTAKE"
+	default:
+		emoji, title = "⏳", "UNSTAKE REQUEST"
+	}
+
+	nameLink := fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>",
+		wallet.AddressDisplay, html.EscapeString(wallet.Name))
+
+	poolFriendly := tonapi.RawToFriendly(st.Pool)
+	poolName := html.EscapeString(st.PoolName)
+	if poolName == "" {
+		poolName = tonapi.ShortAddr(poolFriendly, 4)
+	}
+	poolLink := fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>", poolFriendly, poolName)
+
+	amountLine := "Amount will be known after withdrawal"
+	if st.AmountNano > 0 {
+		amountLine = formatAmount(tonapi.TONAmount(st.AmountNano)) + " TON"
+	}
+
+	lines := []string{
+		fmt.Sprintf("%s <b>%s by %s</b>", emoji, title, nameLink),
+		fmt.Sprintf("<i>via %s</i>", formatPoolImplementation(st.Implementation)),
+		"",
+		amountLine,
+		"",
+		"🏦 Pool: " + poolLink,
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (n *Notifier) formatContractCallMessage(wallet *storage.Wallet, call ContractCall) string {
+	nameLink := fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>",
+		wallet.AddressDisplay, html.EscapeString(wallet.Name))
+
+	contractFriendly := tonapi.RawToFriendly(call.Contract)
+	contractName := html.EscapeString(call.ContractName)
+	if contractName == "" {
+		contractName = tonapi.ShortAddr(contractFriendly, 4)
+	}
+	contractLink := fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>", contractFriendly, contractName)
+
+	operation := html.EscapeString(call.Operation)
+	if operation == "" {
+		operation = "unknown"
+	}
+
+	lines := []string{
+		fmt.Sprintf("⚙️ <b>Contract call by %s</b>", nameLink),
+		"",
+		fmt.Sprintf("Operation: <code>%s</code>", operation),
+		"📄 Contract: " + contractLink,
+	}
+
+	if call.TonAttached > 0 {
+		lines = append(lines, fmt.Sprintf("Attached: %s TON", formatAmount(tonapi.TONAmount(call.TonAttached))))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// summaryCategories lists categories in the order they appear in summaries
+var summaryCategories = []struct {
+	Category storage.NotifyCategory
+	Label    string
+}{
+	{storage.NotifyTonIn, "TON received"},
+	{storage.NotifyTonOut, "TON sent"},
+	{storage.NotifySwapBuy, "Buys"},
+	{storage.NotifySwapSell, "Sells"},
+	{storage.NotifyJettons, "Jetton transfers"},
+	{storage.NotifyNFT, "NFT"},
+	{storage.NotifyStaking, "Staking"},
+	{storage.NotifyContractCalls, "Contract calls"},
+}
+
+func (n *Notifier) formatAwaySummary(wallet *storage.Wallet, missed []notification) string {
+	nameLink := fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>",
+		wallet.AddressDisplay, html.EscapeString(wallet.Name))
+
+	counts := make(map[storage.NotifyCategory]int)
+	for _, msg := range missed {
+		counts[msg.Category]++
+	}
+
+	lines := []string{
+		fmt.Sprintf("⏳ <b>While you were away</b> — %s", nameLink),
+		"",
+		fmt.Sprintf("%d new notifications:", len(missed)),
+	}
+	for _, c := range summaryCategories {
+		if counts[c.Category] > 0 {
+			lines = append(lines, fmt.Sprintf("• %s: %d", c.Label, counts[c.Category]))
+		}
+	}
+	lines = append(lines, "", fmt.Sprintf("<a href='https://tonviewer.com/%s'>Open history</a>", wallet.AddressDisplay))
+
+	return strings.Join(lines, "\n")
+}
+
+// accountLink returns a tonviewer link for an address, using the wallet name
+// for the watched wallet and a shortened address otherwise
+func accountLink(wallet *storage.Wallet, raw string) string {
+	friendly := tonapi.RawToFriendly(raw)
+
+	text := tonapi.ShortAddr(friendly, 4)
+	if raw == wallet.AddressRaw {
+		text = html.EscapeString(wallet.Name)
+	}
+
+	return fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>", friendly, text)
+}
+
+func formatDex(dex string) string {
+	switch strings.ToLower(dex) {
+	case "stonfi", "ston.fi":
+		return "STON.fi"
+	case "dedust":
+		return "DeDust"
+	case "megaton", "megatonfi":
+		return "Megaton"
+	default:
+		if dex != "" {
+			return strings.Title(dex)
+		}
+		return "DEX"
+	}
+}
+
+func formatPoolImplementation(impl string) string {
+	switch impl {
+	case "whales":
+		return "TON Whales"
+	case "tf":
+		return "TON Nominators"
+	case "liquidTF":
+		return "Liquid staking"
+	default:
+		if impl != "" {
+			return impl
+		}
+		return "staking pool"
+	}
+}
+
+func formatMarketplace(auctionType string) string {
+	switch auctionType {
+	case "getgems":
+		return "Getgems"
+	case "DNS.ton":
+		return "TON DNS"
+	case "DNS.tg":
+		return "Fragment (usernames)"
+	case "NUMBER.tg":
+		return "Fragment (numbers)"
+	default:
+		if auctionType != "" {
+			return auctionType
+		}
+		return "marketplace"
+	}
+}
+
+// formatAmount formats an exact amount with thousands grouping. Amounts of at
+// least 1 are rounded to 2 decimals, smaller ones keep 4 significant digits.
+func formatAmount(a tonapi.Amount) string {
+	if a.IsZero() {
+		return "0"
+	}
+
+	units := new(big.Int).Abs(a.Units)
+	scale := tonapi.Pow10(a.Decimals)
+	fractional := units.Cmp(scale) < 0
+
+	prec := 2
+	if fractional {
+		prec = a.Decimals - len(units.String()) + 4
+	}
+	if prec > a.Decimals {
+		prec = a.Decimals
+	}
+
+	// Round half up to prec decimals
+	q := new(big.Int).Mul(units, tonapi.Pow10(prec))
+	q.Add(q, new(big.Int).Quo(scale, big.NewInt(2)))
+	q.Quo(q, scale)
+
+	digits := q.String()
+	if len(digits) <= prec {
+		digits = strings.Repeat("0", prec-len(digits)+1) + digits
+	}
+	intPart, fracPart := digits[:len(digits)-prec], digits[len(digits)-prec:]
+	if fractional {
+		fracPart = strings.TrimRight(fracPart, "0")
+	}
+
+	result := groupThousands(intPart)
+	if fracPart != "" {
+		result += "." + fracPart
+	}
+	if a.Units.Sign() < 0 {
+		result = "-" + result
+	}
+	return result
+}
+
+func groupThousands(digits string) string {
+	var b strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,222 @@
+package notifier
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/suspectuso/ton-tracker/internal/storage"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+const (
+	testWalletRaw = "0:1111111111111111111111111111111111111111111111111111111111111111"
+	testOtherRaw  = "0:2222222222222222222222222222222222222222222222222222222222222222"
+	testNftRaw    = "0:3333333333333333333333333333333333333333333333333333333333333333"
+)
+
+func testWallet() *storage.Wallet {
+	return &storage.Wallet{
+		Name:           "main",
+		AddressRaw:     testWalletRaw,
+		AddressDisplay: tonapi.RawToFriendly(testWalletRaw),
+	}
+}
+
+func TestFormatNftMessageEscapesMetadata(t *testing.T) {
+	const injected = `<a href="https://evil.example">claim</a>`
+
+	tests := []struct {
+		name string
+		nft  Nft
+	}{
+		{
+			name: "transfer",
+			nft: Nft{
+				Kind:           "transfer",
+				Direction:      "in",
+				ItemAddress:    testNftRaw,
+				ItemName:       injected,
+				CollectionName: injected,
+				Sender:         testOtherRaw,
+				Recipient:      testWalletRaw,
+				Comment:        injected,
+			},
+		},
+		{
+			name: "purchase",
+			nft: Nft{
+				Kind:           "purchase",
+				Direction:      "in",
+				ItemAddress:    testNftRaw,
+				ItemName:       injected,
+				CollectionName: injected,
+				Price:          tonapi.TONAmount(1_500_000_000),
+				PriceSymbol:    injected,
+				Marketplace:    injected,
+				Sender:         testOtherRaw,
+				Recipient:      testWalletRaw,
+			},
+		},
+	}
+
+	n := &Notifier{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := n.formatNftMessage(testWallet(), tt.nft)
+			if strings.Contains(text, "evil.example\"") || strings.Contains(text, "<a href=\"") {
+				t.Errorf("metadata not escaped:\n%s", text)
+			}
+			if !strings.Contains(text, "&lt;a href=&#34;https://evil.example&#34;&gt;") {
+				t.Errorf("escaped metadata missing:\n%s", text)
+			}
+		})
+	}
+}
+
+func TestFormatTransferMessagesEscapeUserInput(t *testing.T) {
+	const injected = `<b>free</b>`
+
+	wallet := testWallet()
+	wallet.Name = injected
+
+	n := &Notifier{}
+	tests := []struct {
+		name string
+		text string
+	}{
+		{
+			name: "ton transfer",
+			text: n.formatTransferMessage(wallet, Transfer{
+				Direction:  "in",
+				AmountNano: 1_000_000_000,
+				Sender:     testOtherRaw,
+				Recipient:  testWalletRaw,
+				Comment:    injected,
+			}),
+		},
+		{
+			name: "jetton transfer",
+			text: n.formatJettonTransferMessage(wallet, JettonTransfer{
+				Direction: "in",
+				Symbol:    injected,
+				Amount:    tonapi.JettonAmount("1000000", 6),
+				Sender:    testOtherRaw,
+				Recipient: testWalletRaw,
+				Comment:   injected,
+			}),
+		},
+		{
+			name: "swap",
+			text: n.formatSwapMessage(wallet, Swap{
+				Side:       "buy",
+				FromSymbol: "TON",
+				FromAmount: tonapi.TONAmount(1_000_000_000),
+				ToSymbol:   injected,
+				ToAmount:   tonapi.JettonAmount("1000000", 6),
+			}),
+		},
+		{
+			name: "contract call",
+			text: n.formatContractCallMessage(wallet, ContractCall{
+				Contract:     testOtherRaw,
+				ContractName: injected,
+				Operation:    injected,
+			}),
+		},
+		{
+			name: "away summary",
+			text: n.formatAwaySummary(wallet, []notification{{Category: storage.NotifyTonIn}}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if strings.Contains(tt.text, injected) {
+				t.Errorf("user input not escaped:\n%s", tt.text)
+			}
+		})
+	}
+}
+
+func TestFormatStakeMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		stake Stake
+		want  []string
+	}{
+		{
+			name:  "deposit",
+			stake: Stake{Kind: "deposit", AmountNano: 10_000_000_000, Pool: testOtherRaw, PoolName: "Whales", Implementation: "whales"},
+			want:  []string{"STAKE by", "10.00 TON", "via TON Whales", ">Whales</a>"},
+		},
+		{
+			name:  "withdraw request without amount",
+			stake: Stake{Kind: "withdraw_request", Pool: testOtherRaw, Implementation: "liquidTF"},
+			want:  []string{"UNSTAKE REQUEST", "Amount will be known after withdrawal", "via Liquid staking"},
+		},
+		{
+			name:  "pool name is escaped",
+			stake: Stake{Kind: "withdraw", AmountNano: 1, Pool: testOtherRaw, PoolName: "<i>pool</i>"},
+			want:  []string{"&lt;i&gt;pool&lt;/i&gt;"},
+		},
+	}
+
+	n := &Notifier{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := n.formatStakeMessage(testWallet(), tt.stake)
+			for _, want := range tt.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("missing %q in:\n%s", want, text)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount tonapi.Amount
+		want   string
+	}{
+		{name: "zero", amount: tonapi.Amount{}, want: "0"},
+		{name: "grouped and rounded to 2 decimals", amount: tonapi.TONAmount(1_234_567_890_000), want: "1,234.57"},
+		{name: "half rounds up", amount: tonapi.JettonAmount("1005", 3), want: "1.01"},
+		{name: "keeps trailing zeros above 1", amount: tonapi.TONAmount(10_000_000_000), want: "10.00"},
+		{name: "below 1 keeps 4 significant digits", amount: tonapi.JettonAmount("123456789", 9), want: "0.1235"},
+		{name: "rounds up to 1", amount: tonapi.JettonAmount("999995", 6), want: "1"},
+		{name: "tiny amount keeps every digit", amount: tonapi.TONAmount(1234), want: "0.000001234"},
+		{name: "no decimals", amount: tonapi.JettonAmount("1234567", 0), want: "1,234,567"},
+		{name: "beyond int64", amount: tonapi.JettonAmount("123456789012345678901234567", 9), want: "123,456,789,012,345,678.90"},
+		{name: "negative", amount: tonapi.TONAmount(-1_500_000_000), want: "-1.50"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatAmount(tt.amount); got != tt.want {
+				t.Errorf("formatAmount(%s) = %s, want %s", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBelowTON(t *testing.T) {
+	tests := []struct {
+		nano      int64
+		threshold float64
+		want      bool
+	}{
+		{nano: 100_000_000, threshold: 0.1, want: false},
+		{nano: 99_999_999, threshold: 0.1, want: true},
+		{nano: 5_012_300_000, threshold: 5.0123, want: false},
+		{nano: 1, threshold: 0, want: false},
+		{nano: 0, threshold: 0.000000001, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := belowTON(tt.nano, tt.threshold); got != tt.want {
+			t.Errorf("belowTON(%d, %v) = %v, want %v", tt.nano, tt.threshold, got, tt.want)
+		}
+	}
+}
@@ -2,34 +2,43 @@ package notifier
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/suspectuso/ton-tracker/internal/config"
 	"github.com/suspectuso/ton-tracker/internal/storage"
-	"github.com/suspectuso/ton-tracker/internal/telegram"
 	"github.com/suspectuso/ton-tracker/internal/tonapi"
 )
 
 // Notifier processes events and sends notifications to users
 type Notifier struct {
 	cfg     *config.Config
-	storage *storage.Storage
-	bot     *telegram.Bot
+	storage storage.Store
+	tonAPI  *tonapi.Client
+	outbox  *Outbox
 	log     *slog.Logger
+
+	rates jettonRates
 }
 
 // New creates a new Notifier
-func New(cfg *config.Config, store *storage.Storage, bot *telegram.Bot, log *slog.Logger) *Notifier {
+func New(cfg *config.Config, store storage.Store, tonAPI *tonapi.Client, outbox *Outbox, log *slog.Logger) *Notifier {
 	return &Notifier{
 		cfg:     cfg,
 		storage: store,
-		bot:     bot,
+		tonAPI:  tonAPI,
+		outbox:  outbox,
 		log:     log,
 	}
 }
 
+// notification is a formatted message for one action of an event
+type notification struct {
+	Category storage.NotifyCategory
+	Text     string
+}
+
 // HandleEvent processes an event and sends notifications
 func (n *Notifier) HandleEvent(ctx context.Context, wallet *storage.Wallet, event *tonapi.Event) {
 	n.log.Info("handling event",
@@ -39,69 +48,201 @@ func (n *Notifier) HandleEvent(ctx context.Context, wallet *storage.Wallet, even
 		"actions", len(event.Actions),
 	)
 
+	for _, msg := range n.buildNotifications(ctx, wallet, event) {
+		if err := n.outbox.Enqueue(wallet.UserID, msg.Text); err != nil {
+			n.log.Error("queue notification", "category", msg.Category, "error", err)
+		}
+	}
+}
+
+// buildNotifications formats the messages an event produces for a wallet,
+// honouring its categories and amount filters
+func (n *Notifier) buildNotifications(ctx context.Context, wallet *storage.Wallet, event *tonapi.Event) []notification {
+	var out []notification
+
 	// Extract swaps and transfers
 	swaps := n.extractSwaps(event)
 	transfers := n.extractTransfers(event, wallet.AddressRaw)
+	jettonTransfers := n.extractJettonTransfers(event, wallet.AddressRaw)
+	nfts := n.extractNfts(event, wallet.AddressRaw)
+	stakes := n.extractStakes(event, wallet.AddressRaw)
+	calls := n.extractContractCalls(event, wallet.AddressRaw)
 
 	// Process swaps
 	for _, swap := range swaps {
+		if !wallet.Notifies(swapCategory(swap)) {
+			continue
+		}
+
 		// Apply min amount filter
-		if wallet.MinAmountTON != nil && swap.TonAmount < *wallet.MinAmountTON {
+		if wallet.MinAmountTON != nil && belowTON(swap.TonNano, *wallet.MinAmountTON) {
 			n.log.Debug("skipping swap below min amount",
-				"ton_amount", swap.TonAmount,
+				"ton_nano", swap.TonNano,
 				"min_amount", *wallet.MinAmountTON,
 			)
 			continue
 		}
 
-		text := n.formatSwapMessage(wallet, swap)
-		if err := n.bot.SendNotification(ctx, wallet.UserID, text, nil); err != nil {
-			n.log.Error("send swap notification", "error", err)
+		out = append(out, notification{swapCategory(swap), n.formatSwapMessage(wallet, swap)})
+	}
+
+	// Process NFT transfers and purchases
+	hasPurchase := false
+	for _, nft := range nfts {
+		if nft.Kind == "purchase" {
+			hasPurchase = true
+		}
+		if !wallet.Notifies(storage.NotifyNFT) {
+			continue
 		}
+
+		if nft.Kind == "purchase" {
+			// Apply min amount filter (only purchases carry a price)
+			if wallet.MinAmountTON != nil && nft.PriceSymbol == "TON" {
+				minPrice := tonapi.DecimalAmount(*wallet.MinAmountTON, 9)
+				if nft.Price.Cmp(minPrice) < 0 {
+					continue
+				}
+			}
+		} else {
+			n.fillNftMetadata(ctx, &nft)
+		}
+
+		out = append(out, notification{storage.NotifyNFT, n.formatNftMessage(wallet, nft)})
+	}
+
+	// Process staking actions
+	for _, st := range stakes {
+		if !wallet.Notifies(storage.NotifyStaking) {
+			break
+		}
+
+		// Apply min amount filter (withdraw requests may have no amount)
+		if wallet.MinAmountTON != nil && st.AmountNano > 0 && belowTON(st.AmountNano, *wallet.MinAmountTON) {
+			continue
+		}
+
+		out = append(out, notification{storage.NotifyStaking, n.formatStakeMessage(wallet, st)})
+	}
+
+	// Process contract calls
+	for _, call := range calls {
+		if !wallet.Notifies(storage.NotifyContractCalls) {
+			break
+		}
+
+		out = append(out, notification{storage.NotifyContractCalls, n.formatContractCallMessage(wallet, call)})
 	}
 
-	// Process transfers (only if no swaps to avoid duplicates from swap fees)
-	if len(swaps) == 0 {
+	// Process transfers (only if no swaps, purchases or stakes to avoid duplicates from fees)
+	if len(swaps) == 0 && !hasPurchase && len(stakes) == 0 {
 		for _, tr := range transfers {
+			if !wallet.Notifies(transferCategory(tr)) {
+				continue
+			}
+
 			// Apply min amount filter
-			if wallet.MinAmountTON != nil && tr.Amount < *wallet.MinAmountTON {
+			if wallet.MinAmountTON != nil && belowTON(tr.AmountNano, *wallet.MinAmountTON) {
 				continue
 			}
 
 			// Apply global min transfer filter
-			if tr.Amount < n.cfg.MinTransferTON {
+			if belowTON(tr.AmountNano, n.cfg.MinTransferTON) {
 				continue
 			}
 
-			text := n.formatTransferMessage(wallet, tr)
-			if err := n.bot.SendNotification(ctx, wallet.UserID, text, nil); err != nil {
-				n.log.Error("send transfer notification", "error", err)
+			out = append(out, notification{transferCategory(tr), n.formatTransferMessage(wallet, tr)})
+		}
+
+		for _, jt := range jettonTransfers {
+			if !wallet.Notifies(storage.NotifyJettons) {
+				break
+			}
+
+			// Apply min amount filter using the TON value of the transfer,
+			// jettons without a known rate can't be compared and pass
+			if wallet.MinAmountTON != nil {
+				if value, ok := n.jettonValueTON(ctx, jt); ok && value < *wallet.MinAmountTON {
+					n.log.Debug("skipping jetton transfer below min amount",
+						"symbol", jt.Symbol,
+						"amount", jt.Amount.String(),
+						"min_amount", *wallet.MinAmountTON,
+					)
+					continue
+				}
 			}
+
+			out = append(out, notification{storage.NotifyJettons, n.formatJettonTransferMessage(wallet, jt)})
 		}
 	}
+
+	return out
 }
 
 // Swap represents a parsed swap
 type Swap struct {
-	Dex           string
-	Side          string // "buy" or "sell"
-	FromSymbol    string
-	FromAmount    float64
-	ToSymbol      string
-	ToAmount      float64
-	TonAmount     float64
-	JettonSymbol  string
-	JettonAmount  float64
-	JettonMaster  string
+	Dex          string
+	Side         string // "buy" or "sell"
+	FromSymbol   string
+	FromAmount   tonapi.Amount
+	ToSymbol     string
+	ToAmount     tonapi.Amount
+	TonNano      int64
+	JettonSymbol string
+	JettonAmount tonapi.Amount
+	JettonMaster string
 }
 
 // Transfer represents a parsed transfer
 type Transfer struct {
-	Direction string // "in" or "out"
-	Amount    float64
-	Sender    string
-	Recipient string
-	Comment   string
+	Direction  string // "in" or "out"
+	AmountNano int64
+	Sender     string
+	Recipient  string
+	Comment    string
+}
+
+// JettonTransfer represents a parsed jetton transfer
+type JettonTransfer struct {
+	Direction    string // "in" or "out"
+	Symbol       string
+	Amount       tonapi.Amount
+	JettonMaster string
+	Sender       string
+	Recipient    string
+	Comment      string
+}
+
+// Nft represents a parsed NFT transfer or purchase
+type Nft struct {
+	Kind           string // "transfer" or "purchase"
+	Direction      string // "in" or "out"
+	ItemAddress    string
+	ItemName       string
+	CollectionName string
+	Price          tonapi.Amount // purchases only
+	PriceSymbol    string
+	Marketplace    string
+	Sender         string
+	Recipient      string
+	Comment        string
+}
+
+// Stake represents a parsed staking pool action
+type Stake struct {
+	Kind           string // "deposit", "withdraw" or "withdraw_request"
+	AmountNano     int64  // 0 if unknown
+	Pool           string
+	PoolName       string
+	Implementation string
+}
+
+// ContractCall represents a parsed smart contract call made by the wallet
+type ContractCall struct {
+	Contract     string
+	ContractName string
+	Operation    string
+	TonAttached  int64 // in nanoTON
 }
 
 func (n *Notifier) extractSwaps(event *tonapi.Event) []Swap {
@@ -122,12 +263,12 @@ func (n *Notifier) extractSwaps(event *tonapi.Event) []Swap {
 			// Buying jetton with TON
 			swap.Side = "buy"
 			swap.FromSymbol = "TON"
-			swap.FromAmount = tonapi.NanoToTON(js.TonIn)
-			swap.TonAmount = swap.FromAmount
+			swap.FromAmount = tonapi.TONAmount(js.TonIn)
+			swap.TonNano = js.TonIn
 
 			if js.JettonMasterOut != nil {
 				swap.ToSymbol = js.JettonMasterOut.Symbol
-				swap.ToAmount = tonapi.JettonUnitsToAmount(js.AmountOut, js.JettonMasterOut.Decimals)
+				swap.ToAmount = tonapi.JettonAmount(js.AmountOut, js.JettonMasterOut.Decimals)
 				swap.JettonSymbol = js.JettonMasterOut.Symbol
 				swap.JettonAmount = swap.ToAmount
 				swap.JettonMaster = js.JettonMasterOut.Address
@@ -136,12 +277,12 @@ func (n *Notifier) extractSwaps(event *tonapi.Event) []Swap {
 			// Selling jetton for TON
 			swap.Side = "sell"
 			swap.ToSymbol = "TON"
-			swap.ToAmount = tonapi.NanoToTON(js.TonOut)
-			swap.TonAmount = swap.ToAmount
+			swap.ToAmount = tonapi.TONAmount(js.TonOut)
+			swap.TonNano = js.TonOut
 
 			if js.JettonMasterIn != nil {
 				swap.FromSymbol = js.JettonMasterIn.Symbol
-				swap.FromAmount = tonapi.JettonUnitsToAmount(js.AmountIn, js.JettonMasterIn.Decimals)
+				swap.FromAmount = tonapi.JettonAmount(js.AmountIn, js.JettonMasterIn.Decimals)
 				swap.JettonSymbol = js.JettonMasterIn.Symbol
 				swap.JettonAmount = swap.FromAmount
 				swap.JettonMaster = js.JettonMasterIn.Address
@@ -164,10 +305,10 @@ func (n *Notifier) extractTransfers(event *tonapi.Event, watchedRaw string) []Tr
 
 		tt := action.TonTransfer
 		tr := Transfer{
-			Amount:    tonapi.NanoToTON(tt.Amount),
-			Sender:    tt.Sender.Address,
-			Recipient: tt.Recipient.Address,
-			Comment:   tt.Comment,
+			AmountNano: tt.Amount,
+			Sender:     tt.Sender.Address,
+			Recipient:  tt.Recipient.Address,
+			Comment:    tt.Comment,
 		}
 
 		if tt.Recipient.Address == watchedRaw {
@@ -184,129 +325,244 @@ func (n *Notifier) extractTransfers(event *tonapi.Event, watchedRaw string) []Tr
 	return transfers
 }
 
-func (n *Notifier) formatSwapMessage(wallet *storage.Wallet, swap Swap) string {
-	var emoji, sideWord string
-	switch swap.Side {
-	case "buy":
-		emoji = "✅"
-		sideWord = "BUY"
-	case "sell":
-		emoji = "🔻"
-		sideWord = "SELL"
-	default:
-		emoji = "🔁"
-		sideWord = "SWAP"
-	}
+func (n *Notifier) extractJettonTransfers(event *tonapi.Event, watchedRaw string) []JettonTransfer {
+	var transfers []JettonTransfer
 
-	// Format DEX name nicely
-	dexDisplay := formatDex(swap.Dex)
-
-	// Wallet link
-	nameLink := fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>",
-		wallet.AddressDisplay, wallet.Name)
-
-	// Format amounts
-	var pairLine string
-	if swap.Side == "buy" {
-		pairLine = fmt.Sprintf("%.2f TON 🔄 %s %s",
-			swap.FromAmount, formatNumber(swap.ToAmount), swap.ToSymbol)
-	} else {
-		pairLine = fmt.Sprintf("%s %s 🔄 %.2f TON",
-			formatNumber(swap.FromAmount), swap.FromSymbol, swap.ToAmount)
-	}
+	for _, action := range event.Actions {
+		if action.Type != "JettonTransfer" || action.JettonTransfer == nil {
+			continue
+		}
+
+		jt := action.JettonTransfer
+		tr := JettonTransfer{
+			Symbol:       jt.Jetton.Symbol,
+			Amount:       tonapi.JettonAmount(jt.Amount, jt.Jetton.Decimals),
+			JettonMaster: jt.Jetton.Address,
+			Comment:      jt.Comment,
+		}
+		if jt.Sender != nil {
+			tr.Sender = jt.Sender.Address
+		}
+		if jt.Recipient != nil {
+			tr.Recipient = jt.Recipient.Address
+		}
+
+		if tr.Recipient == watchedRaw {
+			tr.Direction = "in"
+		} else if tr.Sender == watchedRaw {
+			tr.Direction = "out"
+		} else {
+			continue
+		}
 
-	// Token address
-	jettonAddr := ""
-	if swap.JettonMaster != "" {
-		friendly := tonapi.RawToFriendly(swap.JettonMaster)
-		jettonAddr = fmt.Sprintf("\n\n<code>%s</code>", friendly)
+		transfers = append(transfers, tr)
 	}
 
-	return fmt.Sprintf(
-		"%s <b>%s by %s</b>\n"+
-			"<i>via %s</i>\n\n"+
-			"%s%s",
-		emoji, sideWord, nameLink,
-		dexDisplay,
-		pairLine, jettonAddr,
-	)
+	return transfers
 }
 
-func (n *Notifier) formatTransferMessage(wallet *storage.Wallet, tr Transfer) string {
-	var emoji, sign string
-	if tr.Direction == "in" {
-		emoji = "🟩"
-		sign = "+"
-	} else {
-		emoji = "🟥"
-		sign = "-"
-	}
+func (n *Notifier) extractNfts(event *tonapi.Event, watchedRaw string) []Nft {
+	var nfts []Nft
+
+	for _, action := range event.Actions {
+		var nft Nft
+
+		switch {
+		case action.Type == "NftItemTransfer" && action.NftItemTransfer != nil:
+			it := action.NftItemTransfer
+			nft = Nft{
+				Kind:        "transfer",
+				ItemAddress: it.Nft,
+				Comment:     it.Comment,
+			}
+			if it.Sender != nil {
+				nft.Sender = it.Sender.Address
+			}
+			if it.Recipient != nil {
+				nft.Recipient = it.Recipient.Address
+			}
 
-	senderFriendly := tonapi.RawToFriendly(tr.Sender)
-	recipientFriendly := tonapi.RawToFriendly(tr.Recipient)
+		case action.Type == "NftPurchase" && action.NftPurchase != nil:
+			np := action.NftPurchase
+			nft = Nft{
+				Kind:        "purchase",
+				ItemAddress: np.Nft.Address,
+				ItemName:    tonapi.NftItemName(&np.Nft),
+				Price:       tonapi.PriceToAmount(np.Amount),
+				PriceSymbol: np.Amount.TokenName,
+				Marketplace: formatMarketplace(np.AuctionType),
+				Sender:      np.Seller.Address,
+				Recipient:   np.Buyer.Address,
+			}
+			if nft.PriceSymbol == "" {
+				nft.PriceSymbol = "TON"
+			}
+			if np.Nft.Collection != nil {
+				nft.CollectionName = np.Nft.Collection.Name
+			}
+			if np.Nft.Sale != nil && np.Nft.Sale.Market.Name != "" {
+				nft.Marketplace = np.Nft.Sale.Market.Name
+			}
 
-	// Determine display names
-	var senderText, recipientText string
-	if tr.Sender == wallet.AddressRaw {
-		senderText = wallet.Name
-	} else {
-		senderText = tonapi.ShortAddr(senderFriendly, 4)
+		default:
+			continue
+		}
+
+		if nft.Recipient == watchedRaw {
+			nft.Direction = "in"
+		} else if nft.Sender == watchedRaw {
+			nft.Direction = "out"
+		} else {
+			continue
+		}
+
+		nfts = append(nfts, nft)
 	}
 
-	if tr.Recipient == wallet.AddressRaw {
-		recipientText = wallet.Name
-	} else {
-		recipientText = tonapi.ShortAddr(recipientFriendly, 4)
+	return nfts
+}
+
+// fillNftMetadata fetches item and collection names for NFT transfers,
+// which only carry the item address
+func (n *Notifier) fillNftMetadata(ctx context.Context, nft *Nft) {
+	if nft.ItemAddress == "" {
+		return
 	}
 
-	senderLink := fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>", senderFriendly, senderText)
-	recipientLink := fmt.Sprintf("<a href='https://tonviewer.com/%s'>%s</a>", recipientFriendly, recipientText)
+	item, err := n.tonAPI.GetNftItem(ctx, nft.ItemAddress)
+	if err != nil {
+		n.log.Debug("get nft item", "nft", nft.ItemAddress, "error", err)
+		return
+	}
 
-	lines := []string{
-		"<b>🔔 Transfer detected</b>",
-		"",
-		fmt.Sprintf("%s%.2f TON %s", sign, tr.Amount, emoji),
-		"",
-		fmt.Sprintf("%s → %s", senderLink, recipientLink),
+	nft.ItemName = tonapi.NftItemName(item)
+	if item.Collection != nil {
+		nft.CollectionName = item.Collection.Name
 	}
+}
+
+func (n *Notifier) extractStakes(event *tonapi.Event, watchedRaw string) []Stake {
+	var stakes []Stake
 
-	if tr.Comment != "" {
-		lines = append(lines, "", fmt.Sprintf("💬 Comment: <code>%s</code>", tr.Comment))
+	for _, action := range event.Actions {
+		var kind string
+		var as *tonapi.Stake
+
+		switch action.Type {
+		case "DepositStake":
+			kind, as = "deposit", action.DepositStake
+		case "WithdrawStake":
+			kind, as = "withdraw", action.WithdrawStake
+		case "WithdrawStakeRequest":
+			kind, as = "withdraw_request", action.WithdrawStakeRequest
+		}
+		if as == nil || as.Staker.Address != watchedRaw {
+			continue
+		}
+
+		stakes = append(stakes, Stake{
+			Kind:           kind,
+			AmountNano:     as.Amount,
+			Pool:           as.Pool.Address,
+			PoolName:       as.Pool.Name,
+			Implementation: as.Implementation,
+		})
 	}
 
-	return strings.Join(lines, "\n")
+	return stakes
 }
 
-func formatDex(dex string) string {
-	switch strings.ToLower(dex) {
-	case "stonfi", "ston.fi":
-		return "STON.fi"
-	case "dedust":
-		return "DeDust"
-	case "megaton", "megatonfi":
-		return "Megaton"
-	default:
-		if dex != "" {
-			return strings.Title(dex)
+func (n *Notifier) extractContractCalls(event *tonapi.Event, watchedRaw string) []ContractCall {
+	var calls []ContractCall
+
+	for _, action := range event.Actions {
+		if action.Type != "SmartContractExec" || action.SmartContractExec == nil {
+			continue
+		}
+
+		sc := action.SmartContractExec
+		if sc.Executor.Address != watchedRaw {
+			continue
 		}
-		return "DEX"
+
+		calls = append(calls, ContractCall{
+			Contract:     sc.Contract.Address,
+			ContractName: sc.Contract.Name,
+			Operation:    sc.Operation,
+			TonAttached:  sc.TonAttached,
+		})
+	}
+
+	return calls
+}
+
+// swapCategory returns the notification category of a swap.
+// Jetton-to-jetton swaps count as buys.
+func swapCategory(swap Swap) storage.NotifyCategory {
+	if swap.Side == "sell" {
+		return storage.NotifySwapSell
+	}
+	return storage.NotifySwapBuy
+}
+
+// transferCategory returns the notification category of a TON transfer
+func transferCategory(tr Transfer) storage.NotifyCategory {
+	if tr.Direction == "in" {
+		return storage.NotifyTonIn
 	}
+	return storage.NotifyTonOut
+}
+
+// jettonRateTTL is how long a jetton rate, or its absence, is reused. Rates
+// barely move within a poll cycle, and a burst of transfers of one jetton
+// shouldn't cost a rates request each.
+const jettonRateTTL = time.Minute
+
+// jettonRates caches jetton TON rates by master address
+type jettonRates struct {
+	mu    sync.Mutex
+	known map[string]jettonRate
+}
+
+type jettonRate struct {
+	price     float64
+	ok        bool // false if TonAPI has no rate
+	fetchedAt time.Time
 }
 
-func formatNumber(num float64) string {
-	abs := num
-	if abs < 0 {
-		abs = -abs
+// jettonValueTON estimates the TON value of a jetton transfer, ok is false
+// when the jetton has no known rate
+func (n *Notifier) jettonValueTON(ctx context.Context, jt JettonTransfer) (value float64, ok bool) {
+	if jt.JettonMaster == "" {
+		return 0, false
 	}
 
-	switch {
-	case abs >= 1_000_000_000:
-		return fmt.Sprintf("%.2fB", num/1_000_000_000)
-	case abs >= 1_000_000:
-		return fmt.Sprintf("%.2fM", num/1_000_000)
-	case abs >= 1_000:
-		return fmt.Sprintf("%.2fK", num/1_000)
-	default:
-		return fmt.Sprintf("%.2f", num)
+	n.rates.mu.Lock()
+	rate, cached := n.rates.known[jt.JettonMaster]
+	n.rates.mu.Unlock()
+
+	if !cached || time.Since(rate.fetchedAt) > jettonRateTTL {
+		price, err := n.tonAPI.GetJettonPriceTON(ctx, jt.JettonMaster)
+		if err != nil {
+			n.log.Debug("get jetton price", "jetton", jt.JettonMaster, "error", err)
+		}
+		rate = jettonRate{price: price, ok: err == nil, fetchedAt: time.Now()}
+
+		n.rates.mu.Lock()
+		if n.rates.known == nil {
+			n.rates.known = make(map[string]jettonRate)
+		}
+		n.rates.known[jt.JettonMaster] = rate
+		n.rates.mu.Unlock()
 	}
+
+	if !rate.ok {
+		return 0, false
+	}
+	return jt.Amount.Float64() * rate.price, true
+}
+
+// belowTON reports whether a nanoTON amount is less than a TON threshold from settings
+func belowTON(nano int64, threshold float64) bool {
+	return tonapi.TONAmount(nano).Cmp(tonapi.DecimalAmount(threshold, 9)) < 0
 }
@@ -0,0 +1,262 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/storage"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+const (
+	testRatedJetton   = "0:4444444444444444444444444444444444444444444444444444444444444444"
+	testUnratedJetton = "0:5555555555555555555555555555555555555555555555555555555555555555"
+)
+
+// newTestNotifier returns a notifier whose TonAPI knows the given jetton TON
+// rates, and a counter of rate requests
+func newTestNotifier(t *testing.T, rates map[string]float64) (*Notifier, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rates" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+
+		token := r.URL.Query().Get("tokens")
+		resp := tonapi.RatesResponse{Rates: map[string]tonapi.TokenRates{}}
+		if price, ok := rates[token]; ok {
+			resp.Rates[token] = tonapi.TokenRates{Prices: map[string]float64{"TON": price}}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	n := New(&config.Config{}, nil, tonapi.NewClient(srv.URL, "", 1000), nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return n, &requests
+}
+
+// jettonTransferEvent returns an event with one incoming transfer of units of a 9-decimal jetton
+func jettonTransferEvent(master, units string) *tonapi.Event {
+	return &tonapi.Event{
+		EventID: "ev",
+		Actions: []tonapi.Action{{
+			Type: "JettonTransfer",
+			JettonTransfer: &tonapi.JettonTransfer{
+				Sender:    &tonapi.Account{Address: testOtherRaw},
+				Recipient: &tonapi.Account{Address: testWalletRaw},
+				Amount:    units,
+				Jetton:    tonapi.JettonInfo{Address: master, Symbol: "JET", Decimals: 9},
+			},
+		}},
+	}
+}
+
+func TestJettonMinAmountFilter(t *testing.T) {
+	minTON := 10.0
+
+	tests := []struct {
+		name     string
+		minTON   *float64
+		master   string
+		units    string
+		wantSent bool
+	}{
+		{name: "worth more than min", minTON: &minTON, master: testRatedJetton, units: "100000000000", wantSent: true},
+		{name: "worth less than min", minTON: &minTON, master: testRatedJetton, units: "10000000000", wantSent: false},
+		{name: "no rate passes", minTON: &minTON, master: testUnratedJetton, units: "1", wantSent: true},
+		{name: "no min amount", master: testRatedJetton, units: "1", wantSent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, _ := newTestNotifier(t, map[string]float64{testRatedJetton: 0.5})
+			wallet := testWallet()
+			wallet.Notify = storage.NotifyDefault
+			wallet.MinAmountTON = tt.minTON
+
+			got := n.buildNotifications(context.Background(), wallet, jettonTransferEvent(tt.master, tt.units))
+			if sent := len(got) == 1; sent != tt.wantSent {
+				t.Errorf("sent = %v (%d notifications), want %v", sent, len(got), tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestJettonRatesCached(t *testing.T) {
+	n, requests := newTestNotifier(t, map[string]float64{testRatedJetton: 0.5})
+	minTON := 10.0
+	wallet := testWallet()
+	wallet.Notify = storage.NotifyDefault
+	wallet.MinAmountTON = &minTON
+
+	for i := 0; i < 3; i++ {
+		n.buildNotifications(context.Background(), wallet, jettonTransferEvent(testRatedJetton, "100000000000"))
+		n.buildNotifications(context.Background(), wallet, jettonTransferEvent(testUnratedJetton, "1"))
+	}
+
+	// One request per jetton, missing rates are cached too
+	if got := requests.Load(); got != 2 {
+		t.Errorf("rate requests = %d, want 2", got)
+	}
+}
+
+func tonTransfer(from, to string, nano int64) tonapi.Action {
+	return tonapi.Action{
+		Type: "TonTransfer",
+		TonTransfer: &tonapi.TonTransfer{
+			Sender:    tonapi.Account{Address: from},
+			Recipient: tonapi.Account{Address: to},
+			Amount:    nano,
+		},
+	}
+}
+
+func TestBuildNotifications(t *testing.T) {
+	jetton := &tonapi.JettonInfo{Address: testRatedJetton, Symbol: "JET", Decimals: 9}
+	swapBuy := tonapi.Action{Type: "JettonSwap", JettonSwap: &tonapi.JettonSwap{
+		Dex: "stonfi", TonIn: 1_000_000_000, AmountOut: "5000000000", JettonMasterOut: jetton,
+	}}
+	swapSell := tonapi.Action{Type: "JettonSwap", JettonSwap: &tonapi.JettonSwap{
+		Dex: "dedust", TonOut: 1_000_000_000, AmountIn: "5000000000", JettonMasterIn: jetton,
+	}}
+	purchase := tonapi.Action{Type: "NftPurchase", NftPurchase: &tonapi.NftPurchase{
+		AuctionType: "getgems",
+		Amount:      tonapi.Price{Value: "2000000000", TokenName: "TON"},
+		Nft:         tonapi.NftItem{Address: testNftRaw},
+		Seller:      tonapi.Account{Address: testOtherRaw},
+		Buyer:       tonapi.Account{Address: testWalletRaw},
+	}}
+	deposit := tonapi.Action{Type: "DepositStake", DepositStake: &tonapi.Stake{
+		Amount: 10_000_000_000, Staker: tonapi.Account{Address: testWalletRaw},
+		Pool: tonapi.Account{Address: testOtherRaw}, Implementation: "whales",
+	}}
+	call := tonapi.Action{Type: "SmartContractExec", SmartContractExec: &tonapi.SmartContractExec{
+		Executor: tonapi.Account{Address: testWalletRaw}, Contract: tonapi.Account{Address: testOtherRaw}, Operation: "mint",
+	}}
+
+	tests := []struct {
+		name    string
+		actions []tonapi.Action
+		notify  storage.NotifyCategory
+		want    []storage.NotifyCategory
+	}{
+		{
+			name:    "ton in",
+			actions: []tonapi.Action{tonTransfer(testOtherRaw, testWalletRaw, 1_000_000_000)},
+			notify:  storage.NotifyDefault,
+			want:    []storage.NotifyCategory{storage.NotifyTonIn},
+		},
+		{
+			name:    "ton out",
+			actions: []tonapi.Action{tonTransfer(testWalletRaw, testOtherRaw, 1_000_000_000)},
+			notify:  storage.NotifyDefault,
+			want:    []storage.NotifyCategory{storage.NotifyTonOut},
+		},
+		{
+			name:    "ton in disabled",
+			actions: []tonapi.Action{tonTransfer(testOtherRaw, testWalletRaw, 1_000_000_000)},
+			notify:  storage.NotifyDefault &^ storage.NotifyTonIn,
+		},
+		{
+			name:    "ton out disabled keeps ton in",
+			actions: []tonapi.Action{tonTransfer(testWalletRaw, testOtherRaw, 1), tonTransfer(testOtherRaw, testWalletRaw, 1)},
+			notify:  storage.NotifyDefault &^ storage.NotifyTonOut,
+			want:    []storage.NotifyCategory{storage.NotifyTonIn},
+		},
+		{
+			name:    "transfer between other accounts",
+			actions: []tonapi.Action{tonTransfer(testOtherRaw, testNftRaw, 1_000_000_000)},
+			notify:  storage.NotifyDefault,
+		},
+		{
+			name:    "swap buy hides its ton transfer",
+			actions: []tonapi.Action{tonTransfer(testWalletRaw, testOtherRaw, 1_000_000_000), swapBuy},
+			notify:  storage.NotifyDefault,
+			want:    []storage.NotifyCategory{storage.NotifySwapBuy},
+		},
+		{
+			name:    "swap sell",
+			actions: []tonapi.Action{swapSell},
+			notify:  storage.NotifyDefault,
+			want:    []storage.NotifyCategory{storage.NotifySwapSell},
+		},
+		{
+			name:    "swap sell disabled",
+			actions: []tonapi.Action{swapSell, tonTransfer(testOtherRaw, testWalletRaw, 1_000_000_000)},
+			notify:  storage.NotifyDefault &^ storage.NotifySwapSell,
+		},
+		{
+			name:    "swap buy disabled keeps sell",
+			actions: []tonapi.Action{swapBuy, swapSell},
+			notify:  storage.NotifyDefault &^ storage.NotifySwapBuy,
+			want:    []storage.NotifyCategory{storage.NotifySwapSell},
+		},
+		{
+			name:    "nft purchase hides its payment",
+			actions: []tonapi.Action{tonTransfer(testWalletRaw, testOtherRaw, 2_000_000_000), purchase},
+			notify:  storage.NotifyDefault,
+			want:    []storage.NotifyCategory{storage.NotifyNFT},
+		},
+		{
+			name:    "nft disabled still hides the payment",
+			actions: []tonapi.Action{tonTransfer(testWalletRaw, testOtherRaw, 2_000_000_000), purchase},
+			notify:  storage.NotifyDefault &^ storage.NotifyNFT,
+		},
+		{
+			name:    "stake hides its deposit transfer",
+			actions: []tonapi.Action{tonTransfer(testWalletRaw, testOtherRaw, 10_000_000_000), deposit},
+			notify:  storage.NotifyDefault,
+			want:    []storage.NotifyCategory{storage.NotifyStaking},
+		},
+		{
+			name:    "staking disabled still hides the deposit transfer",
+			actions: []tonapi.Action{tonTransfer(testWalletRaw, testOtherRaw, 10_000_000_000), deposit},
+			notify:  storage.NotifyDefault &^ storage.NotifyStaking,
+		},
+		{
+			name:    "contract calls are off by default",
+			actions: []tonapi.Action{call},
+			notify:  storage.NotifyDefault,
+		},
+		{
+			name:    "contract calls enabled",
+			actions: []tonapi.Action{call},
+			notify:  storage.NotifyDefault | storage.NotifyContractCalls,
+			want:    []storage.NotifyCategory{storage.NotifyContractCalls},
+		},
+		{
+			name:    "jetton transfer disabled",
+			actions: jettonTransferEvent(testRatedJetton, "1").Actions,
+			notify:  storage.NotifyDefault &^ storage.NotifyJettons,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, _ := newTestNotifier(t, nil)
+			wallet := testWallet()
+			wallet.Notify = tt.notify
+
+			got := n.buildNotifications(context.Background(), wallet, &tonapi.Event{EventID: "ev", Actions: tt.actions})
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d notifications %v, want categories %v", len(got), got, tt.want)
+			}
+			for i := range got {
+				if got[i].Category != tt.want[i] {
+					t.Errorf("notification %d category = %d, want %d", i, got[i].Category, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package notifier
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/metrics"
+	"github.com/suspectuso/ton-tracker/internal/storage"
+	"github.com/suspectuso/ton-tracker/internal/telegram"
+)
+
+const (
+	outboxBatchSize   = 50
+	outboxLease       = 2 * time.Minute // how long a claimed message is hidden from other workers
+	outboxBaseBackoff = 5 * time.Second
+	outboxMaxBackoff  = time.Hour
+	outboxRetention   = 7 * 24 * time.Hour // sent messages are kept this long
+)
+
+// Outbox persists notifications and delivers them to Telegram with retries,
+// so alerts survive rate limits, network errors and restarts
+type Outbox struct {
+	storage     storage.Store
+	bot         *telegram.Bot
+	log         *slog.Logger
+	maxAttempts int
+
+	wake chan struct{}
+}
+
+// NewOutbox creates a new notification outbox
+func NewOutbox(cfg *config.Config, store storage.Store, bot *telegram.Bot, log *slog.Logger) *Outbox {
+	return &Outbox{
+		storage:     store,
+		bot:         bot,
+		log:         log,
+		maxAttempts: cfg.OutboxMaxAttempts,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Enqueue stores a notification for delivery
+func (o *Outbox) Enqueue(userID int64, text string) error {
+	if err := o.storage.EnqueueNotification(userID, text); err != nil {
+		return err
+	}
+
+	// Deliver right away instead of waiting for the next tick
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start runs the delivery loop until ctx is cancelled.
+// Messages left pending by a previous run are picked up on the first pass.
+func (o *Outbox) Start(ctx context.Context, interval time.Duration) {
+	o.log.Info("notification outbox started", "interval", interval, "max_attempts", o.maxAttempts)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pruneTicker := time.NewTicker(time.Hour)
+	defer pruneTicker.Stop()
+
+	for {
+		o.deliver(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		case <-pruneTicker.C:
+			o.prune()
+		}
+	}
+}
+
+func (o *Outbox) deliver(ctx context.Context) {
+	for ctx.Err() == nil {
+		msgs, err := o.storage.ClaimDueNotifications(outboxBatchSize, outboxLease)
+		if err != nil {
+			o.log.Error("claim notifications", "error", err)
+			return
+		}
+
+		// Users are served concurrently so the bot's send scheduler can
+		// interleave chats, each user's messages stay in order
+		byUser := make(map[int64][]storage.OutboxMessage)
+		for _, msg := range msgs {
+			byUser[msg.UserID] = append(byUser[msg.UserID], msg)
+		}
+
+		var wg sync.WaitGroup
+		for _, userMsgs := range byUser {
+			wg.Add(1)
+			go func(userMsgs []storage.OutboxMessage) {
+				defer wg.Done()
+				for _, msg := range userMsgs {
+					if ctx.Err() != nil {
+						// Unsent claims become due again once the lease expires
+						return
+					}
+					o.send(ctx, msg)
+				}
+			}(userMsgs)
+		}
+		wg.Wait()
+
+		if len(msgs) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+func (o *Outbox) send(ctx context.Context, msg storage.OutboxMessage) {
+	err := o.bot.SendNotification(ctx, msg.UserID, msg.Text, nil)
+	if err == nil {
+		metrics.NotificationsSent.Inc()
+		if err := o.storage.MarkNotificationSent(msg.ID); err != nil {
+			o.log.Error("mark notification sent", "id", msg.ID, "error", err)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// Shutting down, the message is retried after the lease expires
+		return
+	}
+
+	attempts := msg.Attempts + 1
+
+	// Flood limits are not the message's fault, so they don't count as attempts
+	retryAfter, rateLimited := telegram.RetryAfter(err)
+	if !rateLimited && (telegram.IsPermanent(err) || attempts >= o.maxAttempts) {
+		metrics.NotificationsFailed.WithLabelValues("dead").Inc()
+		o.log.Warn("notification dead-lettered",
+			"id", msg.ID,
+			"user_id", msg.UserID,
+			"attempts", attempts,
+			"error", err,
+		)
+		if err := o.storage.MarkNotificationDead(msg.ID, attempts, err.Error()); err != nil {
+			o.log.Error("mark notification dead", "id", msg.ID, "error", err)
+		}
+		return
+	}
+
+	delay := outboxBackoff(attempts)
+	outcome := "retry"
+	if rateLimited {
+		attempts = msg.Attempts
+		delay = retryAfter
+		outcome = "rate_limited"
+	}
+	metrics.NotificationsFailed.WithLabelValues(outcome).Inc()
+
+	o.log.Warn("notification delivery failed, will retry",
+		"id", msg.ID,
+		"user_id", msg.UserID,
+		"attempts", attempts,
+		"retry_in", delay,
+		"error", err,
+	)
+	if err := o.storage.RetryNotification(msg.ID, attempts, time.Now().Add(delay), err.Error()); err != nil {
+		o.log.Error("schedule notification retry", "id", msg.ID, "error", err)
+	}
+}
+
+func (o *Outbox) prune() {
+	n, err := o.storage.PruneSentNotifications(time.Now().Add(-outboxRetention))
+	if err != nil {
+		o.log.Error("prune notifications", "error", err)
+		return
+	}
+	if n > 0 {
+		o.log.Info("pruned sent notifications", "count", n)
+	}
+}
+
+// outboxBackoff returns the exponential delay before the given attempt
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,25 @@
+package notifier
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: outboxBaseBackoff},
+		{attempts: 1, want: outboxBaseBackoff},
+		{attempts: 2, want: 2 * outboxBaseBackoff},
+		{attempts: 4, want: 8 * outboxBaseBackoff},
+		{attempts: 20, want: outboxMaxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -2,31 +2,57 @@ package notifier
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/metrics"
 	"github.com/suspectuso/ton-tracker/internal/storage"
-	"github.com/suspectuso/ton-tracker/internal/telegram"
 	"github.com/suspectuso/ton-tracker/internal/tonapi"
 )
 
-var tgIDRegex = regexp.MustCompile(`(\d{5,15})`)
+var (
+	tgIDRegex = regexp.MustCompile(`(\d{5,15})`)
+
+	// invoiceMemoRegex finds an invoice memo, wallets may change its case
+	invoiceMemoRegex = regexp.MustCompile(`(?i)` + storage.InvoiceMemoPrefix + `[A-Z2-9]{8}`)
+
+	// memoLikeRegex finds what looks like a mistyped or unknown invoice memo,
+	// whose digits must not be taken for a Telegram ID
+	memoLikeRegex = regexp.MustCompile(`(?i)\b` + storage.InvoiceMemoPrefix + `[A-Z0-9]{6,}\b`)
+)
+
+const (
+	// premiumCursorPrefix keeps the service wallet cursor apart from
+	// the cursor of a tracked wallet with the same address
+	premiumCursorPrefix = "premium:"
+
+	// premiumLookback is how far back the first scan of the service wallet goes
+	premiumLookback = 24 * time.Hour
+
+	// expiryCheckInterval is how often reminders and downgrades are processed
+	expiryCheckInterval = 10 * time.Minute
+
+	premiumDateLayout = "02.01.2006"
+)
 
 // PremiumChecker monitors service wallet for premium payments
 type PremiumChecker struct {
 	cfg     *config.Config
-	storage *storage.Storage
+	storage storage.Store
 	tonAPI  *tonapi.Client
-	bot     *telegram.Bot
+	outbox  *Outbox
 	log     *slog.Logger
 
 	serviceWalletRaw string
 }
 
 // NewPremiumChecker creates a new premium checker
-func NewPremiumChecker(cfg *config.Config, store *storage.Storage, tonAPI *tonapi.Client, bot *telegram.Bot, log *slog.Logger) *PremiumChecker {
+func NewPremiumChecker(cfg *config.Config, store storage.Store, tonAPI *tonapi.Client, outbox *Outbox, log *slog.Logger) *PremiumChecker {
 	serviceRaw := ""
 	if cfg.ServiceWalletAddr != "" {
 		serviceRaw = tonapi.NormalizeAddress(cfg.ServiceWalletAddr)
@@ -36,138 +62,437 @@ func NewPremiumChecker(cfg *config.Config, store *storage.Storage, tonAPI *tonap
 		cfg:              cfg,
 		storage:          store,
 		tonAPI:           tonAPI,
-		bot:              bot,
+		outbox:           outbox,
 		log:              log,
 		serviceWalletRaw: serviceRaw,
 	}
 }
 
-// Start starts the premium checker loop
+// Start starts the premium checker loop: payments every interval,
+// expiry reminders and downgrades every expiryCheckInterval
 func (pc *PremiumChecker) Start(ctx context.Context, interval time.Duration) {
 	if pc.serviceWalletRaw == "" {
-		pc.log.Info("premium checker disabled: SERVICE_WALLET_ADDR not set")
-		return
+		pc.log.Info("premium payments disabled: SERVICE_WALLET_ADDR not set")
+	} else {
+		pc.log.Info("premium checker started",
+			"service_wallet", pc.cfg.ServiceWalletAddr,
+			"interval", interval,
+		)
 	}
 
-	pc.log.Info("premium checker started",
-		"service_wallet", pc.cfg.ServiceWalletAddr,
-		"interval", interval,
-	)
-
 	time.Sleep(5 * time.Second) // Initial delay
+	pc.checkExpiry()
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	expiryTicker := time.NewTicker(expiryCheckInterval)
+	defer expiryTicker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			if pc.serviceWalletRaw == "" {
+				continue
+			}
 			if err := pc.checkPayments(ctx); err != nil {
 				pc.log.Error("check payments", "error", err)
 			}
+		case <-expiryTicker.C:
+			pc.checkExpiry()
 		}
 	}
 }
 
 func (pc *PremiumChecker) checkPayments(ctx context.Context) error {
-	events, err := pc.tonAPI.GetEvents(ctx, pc.serviceWalletRaw, 20)
+	cursorKey := premiumCursorPrefix + pc.serviceWalletRaw
+	cursor, err := pc.storage.GetAccountCursor(cursorKey)
 	if err != nil {
 		return err
 	}
 
-	for _, event := range events {
-		pc.processEvent(ctx, &event)
+	// Without a cursor only look back a limited window instead of the whole history
+	oldest := time.Now().Add(-premiumLookback).Unix()
+
+	var events []tonapi.Event
+	err = pc.tonAPI.IterateEvents(ctx, pc.serviceWalletRaw, cursor, 0, func(ev *tonapi.Event) bool {
+		if cursor == 0 && ev.Timestamp < oldest {
+			return false
+		}
+		events = append(events, *ev)
+		return true
+	})
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
+	reverseEvents(events)
+	events = completedEvents(events)
 
-func (pc *PremiumChecker) processEvent(ctx context.Context, event *tonapi.Event) {
-	for _, action := range event.Actions {
-		if action.Type != "TonTransfer" || action.TonTransfer == nil {
-			continue
+	// Stop at an event that failed, it is processed again on the next check
+	processed := 0
+	var processErr error
+	for i := range events {
+		if processErr = pc.processEvent(ctx, &events[i]); processErr != nil {
+			break
 		}
+		processed++
+	}
 
-		tt := action.TonTransfer
-
-		// Only incoming transfers to service wallet
-		recipientRaw := tonapi.NormalizeAddress(tt.Recipient.Address)
-		if recipientRaw != pc.serviceWalletRaw {
-			continue
+	if processed > 0 {
+		if err := pc.storage.AdvanceAccountCursor(cursorKey, events[processed-1].Lt); err != nil {
+			return err
 		}
+	}
+	return processErr
+}
 
-		amount := tonapi.NanoToTON(tt.Amount)
+// processEvent activates premium for payments to the service wallet in an event,
+// returns an error if a payment couldn't be recorded
+func (pc *PremiumChecker) processEvent(ctx context.Context, event *tonapi.Event) error {
+	for _, action := range event.Actions {
+		switch {
+		case action.Type == "TonTransfer" && action.TonTransfer != nil:
+			tt := action.TonTransfer
 
-		// Check if amount is enough for premium (with small tolerance)
-		if amount+0.000001 < pc.cfg.PremiumPriceTON {
-			continue
-		}
+			// Only incoming transfers to service wallet
+			if tonapi.NormalizeAddress(tt.Recipient.Address) != pc.serviceWalletRaw {
+				continue
+			}
+
+			amount := tonapi.TONAmount(tt.Amount)
+			plan := pc.planForAmount(amount, func(plan *config.PremiumPlan) (float64, bool) {
+				return plan.PriceTON, true
+			})
+			if err := pc.activate(event, plan, amount, "TON", tt.Comment, tt.Sender.Address); err != nil {
+				return err
+			}
 
-		// Try to get user ID from comment
-		var userID int64
-		matches := tgIDRegex.FindStringSubmatch(tt.Comment)
-		if len(matches) > 0 {
-			var err error
-			userID, err = parseUserID(matches[1])
-			if err != nil {
+		case action.Type == "JettonTransfer" && action.JettonTransfer != nil:
+			jt := action.JettonTransfer
+			if jt.Recipient == nil || tonapi.NormalizeAddress(jt.Recipient.Address) != pc.serviceWalletRaw {
 				continue
 			}
-		} else {
-			// Try to find user by unique amount
-			var err error
-			userID, err = pc.storage.GetUserByPremiumAmount(amount)
-			if err != nil {
-				pc.log.Debug("premium payment without user ID",
-					"amount", amount,
-					"sender", tt.Sender.Address,
-				)
+
+			// Only accepted jettons, matched by master address as symbols can be faked
+			jetton := pc.acceptedJetton(jt.Jetton.Address)
+			if jetton == nil {
 				continue
 			}
-			pc.log.Info("found user by unique amount",
-				"user_id", userID,
-				"amount", amount,
+
+			sender := ""
+			if jt.Sender != nil {
+				sender = jt.Sender.Address
+			}
+
+			amount := tonapi.JettonAmount(jt.Amount, jt.Jetton.Decimals)
+			plan := pc.planForAmount(amount, func(plan *config.PremiumPlan) (float64, bool) {
+				price, ok := jetton.Prices[plan.ID]
+				return price, ok
+			})
+			if err := pc.activate(event, plan, amount, jetton.Symbol, jt.Comment, sender); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// planForAmount returns the most expensive plan the amount pays for, or nil.
+// price returns the plan price in the payment currency, false if it isn't sold for it.
+// Prices are compared in exact units, so paying the price to the last unit is enough.
+func (pc *PremiumChecker) planForAmount(amount tonapi.Amount, price func(plan *config.PremiumPlan) (float64, bool)) *config.PremiumPlan {
+	var (
+		best      *config.PremiumPlan
+		bestPrice float64
+	)
+	for i := range pc.cfg.PremiumPlans {
+		plan := &pc.cfg.PremiumPlans[i]
+		p, ok := price(plan)
+		if !ok || amount.Cmp(tonapi.DecimalAmount(p, amount.Decimals)) < 0 {
+			continue
+		}
+		if best == nil || p > bestPrice {
+			best, bestPrice = plan, p
+		}
+	}
+	return best
+}
+
+// acceptedJetton returns the configured jetton with the given master address, or nil
+func (pc *PremiumChecker) acceptedJetton(master string) *config.PremiumJetton {
+	masterRaw := tonapi.NormalizeAddress(master)
+	for i := range pc.cfg.PremiumJettons {
+		if tonapi.NormalizeAddress(pc.cfg.PremiumJettons[i].Master) == masterRaw {
+			return &pc.cfg.PremiumJettons[i]
+		}
+	}
+	return nil
+}
+
+// activate grants premium for a payment of amount in currency (TON or a jetton symbol).
+// The payment is matched to an invoice by its memo in the comment or by its unique
+// amount; payments without an invoice need the Telegram ID in the comment.
+// Returns an error if the payment couldn't be recorded.
+func (pc *PremiumChecker) activate(event *tonapi.Event, plan *config.PremiumPlan, amount tonapi.Amount, currency, comment, sender string) error {
+	var userID int64
+	invoice := pc.matchInvoice(amount, currency, comment)
+	switch {
+	case invoice != nil && invoice.Status == storage.InvoicePaid:
+		// Another payment with the memo of a paid invoice buys what the amount pays for
+		userID = invoice.UserID
+		invoice = nil
+	case invoice != nil:
+		userID = invoice.UserID
+		if p := pc.cfg.PremiumPlanByID(invoice.Plan); p != nil {
+			plan = p
+		}
+	case memoLikeRegex.MatchString(comment):
+		// An unknown memo, its digits are no Telegram ID
+		pc.log.Warn("premium payment with an unknown invoice memo, reconcile it manually",
+			"comment", comment,
+			"amount", amount.String(),
+			"currency", currency,
+			"sender", sender,
+			"event_id", event.EventID,
+		)
+		return nil
+	default:
+		matches := tgIDRegex.FindStringSubmatch(comment)
+		if len(matches) == 0 {
+			pc.log.Debug("premium payment without invoice or user ID",
+				"amount", amount.String(),
+				"currency", currency,
+				"sender", sender,
 			)
+			return nil
 		}
 
-		// Check if already processed
-		isNew, err := pc.storage.MarkPremiumPayment(event.EventID, userID, amount, tt.Sender.Address)
+		var err error
+		userID, err = parseUserID(matches[1])
 		if err != nil {
-			pc.log.Error("mark premium payment", "error", err)
-			continue
+			return nil
 		}
-		if !isNew {
-			continue
+	}
+
+	// Check if amount is enough for premium
+	if plan == nil {
+		return nil
+	}
+
+	var invoiceID int64
+	if invoice != nil {
+		invoiceID = invoice.ID
+	}
+
+	// Recorded together with the invoice and premium updates, so a failed
+	// payment isn't marked as processed
+	payment := storage.PremiumPayment{
+		EventID:       event.EventID,
+		UserID:        userID,
+		Amount:        amount,
+		Currency:      currency,
+		SenderAddress: sender,
+	}
+	applied, err := pc.storage.ApplyPremiumPayment(payment, invoiceID, plan.ID, plan.Period)
+	if err != nil {
+		return fmt.Errorf("apply premium payment of user %d: %w", userID, err)
+	}
+	if applied == nil {
+		return nil // already processed
+	}
+
+	if applied.Invoice != nil {
+		pc.log.Info("premium invoice payment",
+			"user_id", userID,
+			"invoice_id", applied.Invoice.ID,
+			"status", applied.Invoice.Status,
+			"amount", amount.String(),
+			"currency", currency,
+		)
+
+		if applied.Invoice.Status != storage.InvoicePaid {
+			pc.notifyUnderpaid(applied.Invoice)
+			return nil
+		}
+	}
+
+	pc.activated(userID, plan, applied.ExpiresAt, amount, currency, sender, event.EventID)
+	return nil
+}
+
+// Grant activates a paid plan and notifies the user. The payment must already
+// be recorded, Telegram Stars payments are granted by the bot through it.
+// Returns an error if premium wasn't extended.
+func (pc *PremiumChecker) Grant(userID int64, plan *config.PremiumPlan, amount tonapi.Amount, currency, sender, eventID string) error {
+	// Activate premium, renewals are added on top of the remaining time
+	expiresAt, err := pc.storage.ExtendPremium(userID, plan.ID, plan.Period, sender, eventID)
+	if err != nil {
+		return fmt.Errorf("activate premium of user %d: %w", userID, err)
+	}
+
+	pc.activated(userID, plan, expiresAt, amount, currency, sender, eventID)
+	return nil
+}
+
+// activated resumes paused wallets and notifies the user after premium was extended
+func (pc *PremiumChecker) activated(userID int64, plan *config.PremiumPlan, expiresAt time.Time, amount tonapi.Amount, currency, sender, eventID string) {
+	metrics.PremiumActivations.Inc()
+
+	// Wallets paused after a previous expiry are tracked again
+	resumed, err := pc.storage.ResumeWallets(userID, pc.walletLimit(userID, true))
+	if err != nil {
+		pc.log.Error("resume wallets", "error", err, "user_id", userID)
+	}
+
+	pc.log.Info("premium activated",
+		"user_id", userID,
+		"plan", plan.ID,
+		"amount", amount.String(),
+		"currency", currency,
+		"expires_at", expiresAt,
+		"resumed_wallets", resumed,
+		"sender", sender,
+		"event_id", eventID,
+	)
+
+	// Notify user
+	text := "⭐ <b>Premium активирован!</b>\n\n" +
+		"Тариф: <b>" + plan.Title + "</b>, действует до <b>" + expiresAt.Format(premiumDateLayout) + "</b>.\n" +
+		"Теперь твой лимит — до <b>" + strconv.Itoa(pc.walletLimit(userID, true)) + "</b> кошельков.\n"
+	if resumed > 0 {
+		text += "Приостановленные кошельки снова отслеживаются: <b>" + strconv.Itoa(resumed) + "</b>.\n"
+	}
+	text += "Спасибо за поддержку 💙"
+
+	if err := pc.outbox.Enqueue(userID, text); err != nil {
+		pc.log.Error("queue premium notification", "error", err)
+	}
+}
+
+// matchInvoice returns the invoice whose memo is in the comment, or the unpaid
+// invoice reserving exactly this amount, or nil
+func (pc *PremiumChecker) matchInvoice(amount tonapi.Amount, currency, comment string) *storage.PremiumInvoice {
+	var (
+		invoice *storage.PremiumInvoice
+		err     error
+	)
+	if memo := invoiceMemoRegex.FindString(comment); memo != "" {
+		invoice, err = pc.storage.GetPremiumInvoiceByMemo(strings.ToUpper(memo))
+	} else {
+		invoice, err = pc.storage.FindPremiumInvoiceByAmount(currency, amount)
+	}
+	if err != nil {
+		if err != storage.ErrNotFound {
+			pc.log.Error("find premium invoice", "error", err)
 		}
+		return nil
+	}
+
+	// A memo paid in another currency can't be compared with the invoice amount
+	if invoice.Currency != currency || invoice.Amount.Decimals != amount.Decimals {
+		pc.log.Warn("premium payment currency doesn't match invoice",
+			"invoice_id", invoice.ID,
+			"invoice_currency", invoice.Currency,
+			"currency", currency,
+			"decimals", amount.Decimals,
+		)
+		return nil
+	}
+	return invoice
+}
+
+// notifyUnderpaid tells the user how much is left to pay for an invoice
+func (pc *PremiumChecker) notifyUnderpaid(invoice *storage.PremiumInvoice) {
+	amount := func(v tonapi.Amount) string {
+		return v.String() + " " + invoice.Currency
+	}
+	text := "💸 <b>Платёж получен не полностью</b>\n\n" +
+		"Получено <b>" + amount(invoice.PaidAmount) + "</b> из <b>" + amount(invoice.Amount) + "</b>.\n" +
+		"Доплати <b>" + amount(invoice.Remaining()) + "</b> на тот же кошелёк с комментарием " +
+		"<code>" + invoice.Memo + "</code> — Premium активируется автоматически."
+
+	if err := pc.outbox.Enqueue(invoice.UserID, text); err != nil {
+		pc.log.Error("queue underpayment notification", "error", err)
+	}
+}
+
+// checkExpiry reminds users about premium ending soon and moves expired
+// users back to the free limit, pausing wallets over it
+func (pc *PremiumChecker) checkExpiry() {
+	if _, err := pc.storage.ExpirePremiumInvoices(); err != nil {
+		pc.log.Error("expire premium invoices", "error", err)
+	}
+
+	remindBefore := time.Now().Add(time.Duration(pc.cfg.PremiumReminderDays) * 24 * time.Hour)
+	toRemind, err := pc.storage.ListPremiumToRemind(remindBefore)
+	if err != nil {
+		pc.log.Error("list premium to remind", "error", err)
+	}
+	for _, p := range toRemind {
+		text := "⏳ <b>Premium заканчивается " + p.ExpiresAt.Format(premiumDateLayout) + "</b>\n\n" +
+			"Продли его в меню ⭐ Premium — оставшиеся дни сохранятся.\n" +
+			"После окончания лимит снизится до <b>" + strconv.Itoa(pc.walletLimit(p.UserID, false)) + "</b> кошельков, " +
+			"лишние кошельки будут приостановлены, но не удалены."
 
-		// Activate premium
-		if err := pc.storage.ActivatePremium(userID, tt.Sender.Address, event.EventID); err != nil {
-			pc.log.Error("activate premium", "error", err)
+		if err := pc.outbox.Enqueue(p.UserID, text); err != nil {
+			pc.log.Error("queue premium reminder", "error", err)
 			continue
 		}
+		if err := pc.storage.MarkPremiumReminded(p.UserID); err != nil {
+			pc.log.Error("mark premium reminded", "error", err)
+		}
+	}
 
-		// Clear pending payment
-		pc.storage.ClearPendingPremium(userID)
+	expired, err := pc.storage.ListExpiredPremium()
+	if err != nil {
+		pc.log.Error("list expired premium", "error", err)
+		return
+	}
+	for _, p := range expired {
+		limit := pc.walletLimit(p.UserID, false)
+		paused, err := pc.storage.PauseExcessWallets(p.UserID, limit)
+		if err != nil {
+			pc.log.Error("pause excess wallets", "error", err, "user_id", p.UserID)
+			continue
+		}
+		if err := pc.storage.MarkPremiumDowngraded(p.UserID); err != nil {
+			pc.log.Error("mark premium downgraded", "error", err)
+			continue
+		}
 
-		pc.log.Info("premium activated",
-			"user_id", userID,
-			"amount", amount,
-			"sender", tt.Sender.Address,
-			"event_id", event.EventID,
+		pc.log.Info("premium expired",
+			"user_id", p.UserID,
+			"plan", p.Plan,
+			"paused_wallets", paused,
 		)
 
-		// Notify user
-		text := "⭐ <b>Premium активирован!</b>\n\n" +
-			"Теперь твой лимит — до <b>" + formatNumber(float64(pc.cfg.PremiumMaxWalletsPerUser)) + "</b> кошельков.\n" +
-			"Спасибо за поддержку 💙"
+		text := "⌛ <b>Premium закончился</b>\n\n" +
+			"Лимит снова <b>" + strconv.Itoa(limit) + "</b> кошельков."
+		if paused > 0 {
+			text += "\nПриостановлено кошельков: <b>" + strconv.Itoa(paused) + "</b>. " +
+				"Они не удалены и снова будут отслеживаться после продления Premium."
+		}
 
-		if err := pc.bot.SendNotification(ctx, userID, text, nil); err != nil {
-			pc.log.Error("send premium notification", "error", err)
+		if err := pc.outbox.Enqueue(p.UserID, text); err != nil {
+			pc.log.Error("queue premium expiry notification", "error", err)
 		}
 	}
 }
 
+// walletLimit returns the wallet limit of a user with or without premium, VIP limits take precedence
+func (pc *PremiumChecker) walletLimit(userID int64, premium bool) int {
+	switch {
+	case pc.cfg.VIPUserIDs[userID]:
+		return pc.cfg.VIPMaxWalletsPerUser
+	case premium:
+		return pc.cfg.PremiumMaxWalletsPerUser
+	default:
+		return pc.cfg.MaxWalletsPerUser
+	}
+}
+
 func parseUserID(s string) (int64, error) {
 	var id int64
 	for _, c := range s {
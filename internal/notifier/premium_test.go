@@ -0,0 +1,228 @@
+package notifier
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/storage"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+// newTestPremiumChecker returns a checker on a fresh SQLite store
+func newTestPremiumChecker(t *testing.T, cfg *config.Config) (*PremiumChecker, *storage.Storage) {
+	t.Helper()
+
+	store, err := storage.New("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewPremiumChecker(cfg, store, nil, NewOutbox(cfg, store, nil, log), log), store
+}
+
+// queuedTexts returns the notifications waiting in the outbox
+func queuedTexts(t *testing.T, store *storage.Storage) []string {
+	t.Helper()
+
+	msgs, err := store.ClaimDueNotifications(100, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := make([]string, len(msgs))
+	for i, m := range msgs {
+		texts[i] = m.Text
+	}
+	return texts
+}
+
+func TestPremiumWalletCounts(t *testing.T) {
+	const userID = 12345
+	serviceWallet := "0:" + strings.Repeat("f", 64)
+
+	tests := []struct {
+		name    string
+		vip     bool
+		paused  int
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "premium limit",
+			want:    []string{"до <b>100</b> кошельков"},
+			notWant: []string{"100.00", "K</b>", "Приостановленные"},
+		},
+		{
+			name: "vip limit",
+			vip:  true,
+			want: []string{"до <b>10</b> кошельков"},
+		},
+		{
+			name:   "resumed wallets",
+			paused: 5,
+			want:   []string{"снова отслеживаются: <b>5</b>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				MaxWalletsPerUser:        3,
+				PremiumMaxWalletsPerUser: 100,
+				VIPMaxWalletsPerUser:     10,
+				VIPUserIDs:               map[int64]bool{},
+				ServiceWalletAddr:        serviceWallet,
+				PremiumPlans:             []config.PremiumPlan{{ID: "month", Title: "1 месяц", Period: 30 * 24 * time.Hour, PriceTON: 5}},
+			}
+			if tt.vip {
+				cfg.VIPUserIDs[userID] = true
+			}
+			pc, store := newTestPremiumChecker(t, cfg)
+
+			for i := 0; i < cfg.MaxWalletsPerUser+tt.paused; i++ {
+				addr := "0:" + strings.Repeat("a", 63) + string(rune('a'+i))
+				if _, err := store.AddWallet(userID, "w", addr, addr, 100); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := store.PauseExcessWallets(userID, cfg.MaxWalletsPerUser); err != nil {
+				t.Fatal(err)
+			}
+
+			pc.processEvent(context.Background(), &tonapi.Event{
+				EventID: "ev1",
+				Actions: []tonapi.Action{{
+					Type: "TonTransfer",
+					TonTransfer: &tonapi.TonTransfer{
+						Recipient: tonapi.Account{Address: serviceWallet},
+						Amount:    5_000_000_000,
+						Comment:   "12345",
+					},
+				}},
+			})
+
+			texts := queuedTexts(t, store)
+			if len(texts) != 1 {
+				t.Fatalf("queued %d messages, want 1", len(texts))
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(texts[0], want) {
+					t.Errorf("missing %q in:\n%s", want, texts[0])
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(texts[0], notWant) {
+					t.Errorf("unexpected %q in:\n%s", notWant, texts[0])
+				}
+			}
+		})
+	}
+}
+
+func TestPlanForAmount(t *testing.T) {
+	cfg := &config.Config{
+		PremiumPlans: []config.PremiumPlan{
+			{ID: "month", PriceTON: 5},
+			{ID: "quarter", PriceTON: 13.1},
+			{ID: "year", PriceTON: 45},
+		},
+	}
+	usdt := &config.PremiumJetton{Symbol: "USDT", Prices: map[string]float64{"month": 2.5, "year": 22}}
+	pc := &PremiumChecker{cfg: cfg}
+
+	tonPrice := func(plan *config.PremiumPlan) (float64, bool) { return plan.PriceTON, true }
+	usdtPrice := func(plan *config.PremiumPlan) (float64, bool) {
+		price, ok := usdt.Prices[plan.ID]
+		return price, ok
+	}
+
+	tests := []struct {
+		name   string
+		amount tonapi.Amount
+		price  func(plan *config.PremiumPlan) (float64, bool)
+		want   string // plan ID, empty for none
+	}{
+		{name: "exact monthly price", amount: tonapi.TONAmount(5_000_000_000), price: tonPrice, want: "month"},
+		{name: "one nanoTON short", amount: tonapi.TONAmount(4_999_999_999), price: tonPrice},
+		{name: "invoice suffix on top", amount: tonapi.TONAmount(13_100_700_000), price: tonPrice, want: "quarter"},
+		{name: "fractional price exactly", amount: tonapi.TONAmount(13_100_000_000), price: tonPrice, want: "quarter"},
+		{name: "just below fractional price", amount: tonapi.TONAmount(13_099_999_999), price: tonPrice, want: "month"},
+		{name: "overpaid", amount: tonapi.TONAmount(100_000_000_000), price: tonPrice, want: "year"},
+		{name: "jetton exact", amount: tonapi.JettonAmount("2500000", 6), price: usdtPrice, want: "month"},
+		{name: "jetton one unit short", amount: tonapi.JettonAmount("2499999", 6), price: usdtPrice},
+		{name: "jetton plan not sold", amount: tonapi.JettonAmount("15000000", 6), price: usdtPrice, want: "month"},
+		{name: "jetton 18 decimals", amount: tonapi.JettonAmount("22000000000000000000", 18), price: usdtPrice, want: "year"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if plan := pc.planForAmount(tt.amount, tt.price); plan != nil {
+				got = plan.ID
+			}
+			if got != tt.want {
+				t.Errorf("plan = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActivateMatchesPayer(t *testing.T) {
+	cfg := &config.Config{
+		MaxWalletsPerUser:        3,
+		PremiumMaxWalletsPerUser: 100,
+		PremiumPlans:             []config.PremiumPlan{{ID: "month", Title: "1 месяц", PriceTON: 5, Period: 30 * 24 * time.Hour}},
+	}
+
+	tests := []struct {
+		name    string
+		comment string
+		want    int64 // user granted premium, 0 for none
+	}{
+		{name: "invoice memo", comment: "{memo}", want: 1},
+		{name: "telegram id", comment: "id 123456789", want: 123456789},
+		{name: "unknown memo", comment: "TT23456789", want: 0},
+		{name: "mistyped memo", comment: "tt2345678", want: 0},
+		{name: "nothing to match", comment: "thanks", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc, store := newTestPremiumChecker(t, cfg)
+			inv, err := store.CreatePremiumInvoice(1, "month", "TON", tonapi.DecimalAmount(5, 9), time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Not the invoice amount, so only the comment can match a user
+			amount := tonapi.DecimalAmount(7, 9)
+			comment := strings.ReplaceAll(tt.comment, "{memo}", inv.Memo)
+			if err := pc.activate(&tonapi.Event{EventID: "ev1"}, &cfg.PremiumPlans[0], amount, "TON", comment, "0:payer"); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, user := range []int64{1, 123456789, 23456789, 2345678} {
+				if got := store.IsPremium(user); got != (user == tt.want) {
+					t.Errorf("user %d premium = %v, want %v", user, got, user == tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGrantError(t *testing.T) {
+	pc, store := newTestPremiumChecker(t, &config.Config{MaxWalletsPerUser: 3, PremiumMaxWalletsPerUser: 100})
+	store.Close()
+
+	err := pc.Grant(1, &config.PremiumPlan{ID: "month", Period: 30 * 24 * time.Hour}, tonapi.DecimalAmount(250, 0), "XTR", "", "stars:charge")
+	if err == nil {
+		t.Error("Grant succeeded without a database")
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// dialect describes the differences between supported databases.
+// Queries are written with ? placeholders and portable SQL
+// (ON CONFLICT, RETURNING) so both backends share one implementation.
+type dialect struct {
+	name          string
+	driver        string
+	numberedBinds bool // $1, $2, ... instead of ?
+}
+
+var (
+	dialectSQLite   = dialect{name: "sqlite", driver: "sqlite3"}
+	dialectPostgres = dialect{name: "postgres", driver: "postgres", numberedBinds: true}
+)
+
+func dialectFor(driver string) (dialect, error) {
+	switch driver {
+	case "", "sqlite", "sqlite3":
+		return dialectSQLite, nil
+	case "postgres", "postgresql":
+		return dialectPostgres, nil
+	default:
+		return dialect{}, fmt.Errorf("unsupported database driver %q", driver)
+	}
+}
+
+// dataSource returns the driver-specific connection string
+func (d dialect) dataSource(dsn string) string {
+	if d == dialectSQLite {
+		return dsn + "?_journal_mode=WAL&_busy_timeout=5000"
+	}
+	return dsn
+}
+
+// rebind converts ? placeholders to the dialect's bind style
+func (d dialect) rebind(query string) string {
+	if !d.numberedBinds {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// querier is a *sql.DB or a *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s *Storage) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.dialect.rebind(query), args...)
+}
+
+func (s *Storage) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.rebind(query), args...)
+}
+
+func (s *Storage) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.dialect.rebind(query), args...)
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect dialect
+		query   string
+		want    string
+	}{
+		{
+			name:    "sqlite keeps placeholders",
+			dialect: dialectSQLite,
+			query:   "SELECT id FROM wallets WHERE user_id = ? AND name = ?",
+			want:    "SELECT id FROM wallets WHERE user_id = ? AND name = ?",
+		},
+		{
+			name:    "postgres numbers placeholders",
+			dialect: dialectPostgres,
+			query:   "SELECT id FROM wallets WHERE user_id = ? AND name = ?",
+			want:    "SELECT id FROM wallets WHERE user_id = $1 AND name = $2",
+		},
+		{
+			name:    "postgres without placeholders",
+			dialect: dialectPostgres,
+			query:   "SELECT COUNT(*) FROM wallets",
+			want:    "SELECT COUNT(*) FROM wallets",
+		},
+		{
+			name:    "postgres keeps multibyte text",
+			dialect: dialectPostgres,
+			query:   "INSERT INTO t (a, b) VALUES (?, 'кошелёк')",
+			want:    "INSERT INTO t (a, b) VALUES ($1, 'кошелёк')",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dialect.rebind(tt.query); got != tt.want {
+				t.Errorf("rebind = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialectFor(t *testing.T) {
+	tests := []struct {
+		driver  string
+		want    dialect
+		wantErr bool
+	}{
+		{driver: "", want: dialectSQLite},
+		{driver: "sqlite3", want: dialectSQLite},
+		{driver: "postgresql", want: dialectPostgres},
+		{driver: "mysql", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := dialectFor(tt.driver)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("dialectFor(%q) = %v, %v", tt.driver, got, err)
+		}
+	}
+}
+
+// TestMigratePostgres runs the PostgreSQL migrations against an empty
+// database given in TEST_POSTGRES_DSN, skipped when it is not set
+func TestMigratePostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+
+	s, err := New("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open and migrate: %v", err)
+	}
+	defer s.Close()
+
+	if applied, err := s.Migrate(); err != nil || applied != 0 {
+		t.Errorf("second migrate applied %d (%v), want 0", applied, err)
+	}
+
+	w, err := s.AddWallet(1, "main", "0:pgtest", "EQpgtest", 10)
+	if err != nil {
+		t.Fatalf("add wallet: %v", err)
+	}
+	defer s.RemoveWallet(1, w.ID)
+
+	got, err := s.GetWallet(w.ID)
+	if err != nil || got.Notify != NotifyDefault {
+		t.Errorf("get wallet = %+v (%v)", got, err)
+	}
+}
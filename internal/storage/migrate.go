@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/migrations"
+)
+
+// migration is a single numbered schema change
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations reads NNNN_name.sql files sorted by version
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var list []migration
+	seen := make(map[int]string)
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".sql")
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name.sql", file)
+		}
+
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version", file)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migration %s: version %d already used by %s", file, version, other)
+		}
+		seen[version] = file
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, migration{Version: version, Name: name, SQL: string(data)})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// migrationLockID is the PostgreSQL advisory lock key that serializes
+// migrations when several bot instances start at once
+const migrationLockID = 7315829401
+
+// Migrate applies pending migrations, each in its own transaction.
+// Returns the number of applied migrations.
+func (s *Storage) Migrate() (int, error) {
+	dir, err := fs.Sub(migrations.FS, s.dialect.name)
+	if err != nil {
+		return 0, err
+	}
+
+	list, err := loadMigrations(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.createVersionTable(); err != nil {
+		return 0, err
+	}
+
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	if current == 0 && s.dialect == dialectSQLite {
+		if current, err = s.baselineLegacy(list); err != nil {
+			return 0, err
+		}
+	}
+
+	applied := 0
+	for _, m := range list {
+		if m.Version <= current {
+			continue
+		}
+		ok, err := s.applyMigration(m)
+		if err != nil {
+			return applied, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+		if ok {
+			applied++
+		}
+	}
+
+	return applied, nil
+}
+
+// SchemaVersion returns the latest applied migration version
+func (s *Storage) SchemaVersion() (int, error) {
+	var version int
+	err := s.queryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+func (s *Storage) createVersionTable() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.lockMigrations(tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// applyMigration runs a migration unless another instance already did,
+// returns true if it was applied
+func (s *Storage) applyMigration(m migration) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := s.lockMigrations(tx); err != nil {
+		return false, err
+	}
+
+	var count int
+	err = tx.QueryRow(
+		s.dialect.rebind("SELECT COUNT(*) FROM schema_version WHERE version = ?"),
+		m.Version,
+	).Scan(&count)
+	if err != nil || count > 0 {
+		return false, err
+	}
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(
+		s.dialect.rebind("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"),
+		m.Version, m.Name, time.Now().Unix(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// lockMigrations takes a transaction-scoped lock on PostgreSQL.
+// SQLite serializes writers on its own.
+func (s *Storage) lockMigrations(tx *sql.Tx) error {
+	if s.dialect != dialectPostgres {
+		return nil
+	}
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockID)
+	return err
+}
+
+// baselineLegacy records migrations already present in SQLite databases
+// created before schema_version existed, detected by their tables and columns
+func (s *Storage) baselineLegacy(list []migration) (int, error) {
+	hasWallets, err := s.hasTable("wallets")
+	if err != nil || !hasWallets {
+		return 0, err
+	}
+
+	version := 1
+	hasCategories, err := s.hasColumn("wallets", "notify_categories")
+	if err != nil {
+		return 0, err
+	}
+	if hasCategories {
+		version = 2
+	}
+
+	now := time.Now().Unix()
+	for _, m := range list {
+		if m.Version > version {
+			break
+		}
+		_, err := s.exec(
+			"INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Name, now,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return version, nil
+}
+
+func (s *Storage) hasTable(table string) (bool, error) {
+	var count int
+	err := s.queryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+		table,
+	).Scan(&count)
+	return count > 0, err
+}
+
+func (s *Storage) hasColumn(table, column string) (bool, error) {
+	var count int
+	err := s.queryRow(
+		"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?",
+		table, column,
+	).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/suspectuso/ton-tracker/migrations"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int
+		wantErr  bool
+	}{
+		{
+			name: "sorted by version",
+			files: fstest.MapFS{
+				"0010_ten.sql": {Data: []byte("SELECT 10")},
+				"0002_two.sql": {Data: []byte("SELECT 2")},
+				"0001_one.sql": {Data: []byte("SELECT 1")},
+				"README.md":    {Data: []byte("ignored")},
+			},
+			versions: []int{1, 2, 10},
+		},
+		{
+			name:    "missing name",
+			files:   fstest.MapFS{"0001.sql": {}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid version",
+			files:   fstest.MapFS{"first_one.sql": {}},
+			wantErr: true,
+		},
+		{
+			name:    "zero version",
+			files:   fstest.MapFS{"0000_zero.sql": {}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"0001_one.sql":   {},
+				"0001_other.sql": {},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := loadMigrations(tt.files)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if len(list) != len(tt.versions) {
+				t.Fatalf("got %d migrations, want %d", len(list), len(tt.versions))
+			}
+			for i, m := range list {
+				if m.Version != tt.versions[i] {
+					t.Errorf("migration %d version = %d, want %d", i, m.Version, tt.versions[i])
+				}
+			}
+		})
+	}
+}
+
+// Versions must stay in step across dialect directories
+func TestMigrationDirectoriesMatch(t *testing.T) {
+	versions := make(map[string][]string)
+	for _, d := range []dialect{dialectSQLite, dialectPostgres} {
+		dir, err := fs.Sub(migrations.FS, d.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, err := loadMigrations(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", d.name, err)
+		}
+		for _, m := range list {
+			versions[d.name] = append(versions[d.name], m.Name)
+		}
+	}
+
+	sqlite, postgres := versions[dialectSQLite.name], versions[dialectPostgres.name]
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("sqlite has %d migrations, postgres %d", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i] != postgres[i] {
+			t.Errorf("migration %d: sqlite %s, postgres %s", i, sqlite[i], postgres[i])
+		}
+	}
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	dir, err := fs.Sub(migrations.FS, dialectSQLite.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := loadMigrations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := list[len(list)-1].Version
+
+	tests := []struct {
+		name        string
+		setup       string // schema created before Migrate
+		wantApplied int
+	}{
+		{
+			name:        "empty database",
+			wantApplied: len(list),
+		},
+		{
+			name:        "legacy database without categories",
+			setup:       list[0].SQL,
+			wantApplied: len(list) - 1,
+		},
+		{
+			name:        "legacy database with categories",
+			setup:       list[0].SQL + list[1].SQL,
+			wantApplied: len(list) - 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			if tt.setup != "" {
+				if _, err := s.db.Exec(tt.setup); err != nil {
+					t.Fatalf("setup: %v", err)
+				}
+			}
+
+			applied, err := s.Migrate()
+			if err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+			if applied != tt.wantApplied {
+				t.Errorf("applied = %d, want %d", applied, tt.wantApplied)
+			}
+
+			version, err := s.SchemaVersion()
+			if err != nil || version != latest {
+				t.Errorf("version = %d (%v), want %d", version, err, latest)
+			}
+
+			if applied, err := s.Migrate(); err != nil || applied != 0 {
+				t.Errorf("second migrate applied %d (%v), want 0", applied, err)
+			}
+		})
+	}
+}
+
+func TestMigrateLegacyPremiumPayments(t *testing.T) {
+	s, err := Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	baseline, err := migrations.FS.ReadFile("sqlite/0001_initial_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.db.Exec(string(baseline) +
+		"INSERT INTO premium_payments (event_id, user_id, amount, sender_address) VALUES ('legacy', 1, 5.0123, 'EQsender');")
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	if _, err := s.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	var amount, currency, sender string
+	err = s.queryRow("SELECT amount, currency, sender_address FROM premium_payments WHERE event_id = ?", "legacy").
+		Scan(&amount, &currency, &sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amount != "5.0123" || currency != "TON" || sender != "EQsender" {
+		t.Errorf("migrated payment = %s %s from %s, want 5.0123 TON from EQsender", amount, currency, sender)
+	}
+}
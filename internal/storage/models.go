@@ -1,6 +1,11 @@
 package storage
 
-import "time"
+import (
+	"math/big"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
 
 // Wallet represents a tracked TON wallet
 type Wallet struct {
@@ -10,17 +15,58 @@ type Wallet struct {
 	AddressRaw     string // 0:... format
 	AddressDisplay string // UQ.../EQ... format
 	MinAmountTON   *float64
+	Notify         NotifyCategory
 	CreatedAt      time.Time
+	Paused         bool // over the limit after premium expired, not tracked
+}
+
+// NotifyCategory is a set of event categories a wallet notifies about
+type NotifyCategory int
+
+const (
+	NotifyTonIn NotifyCategory = 1 << iota
+	NotifyTonOut
+	NotifySwapBuy
+	NotifySwapSell
+	NotifyJettons
+	NotifyNFT
+	NotifyStaking
+	NotifyContractCalls
+
+	// NotifyDefault enables everything except contract calls
+	NotifyDefault = NotifyTonIn | NotifyTonOut | NotifySwapBuy | NotifySwapSell |
+		NotifyJettons | NotifyNFT | NotifyStaking
+)
+
+// WalletChange is published when a wallet is added or removed.
+// Resync replaces changes dropped for a slow reader, which should then
+// resync all addresses instead of applying a single one.
+type WalletChange struct {
+	AddressRaw string
+	Removed    bool
+	Resync     bool
+}
+
+// Notifies reports whether the wallet has a notification category enabled
+func (w *Wallet) Notifies(category NotifyCategory) bool {
+	return w.Notify&category != 0
 }
 
 // PremiumUser represents a user with premium subscription
 type PremiumUser struct {
 	UserID       int64
 	ActivatedAt  time.Time
+	ExpiresAt    time.Time
+	Plan         string
 	PayerAddress string
 	EventID      string
 }
 
+// Active reports whether the premium period hasn't ended
+func (p *PremiumUser) Active() bool {
+	return time.Now().Before(p.ExpiresAt)
+}
+
 // ProcessedEvent tracks which events have been processed to avoid duplicates
 type ProcessedEvent struct {
 	WalletID int64
@@ -31,13 +77,68 @@ type ProcessedEvent struct {
 type PremiumPayment struct {
 	EventID       string
 	UserID        int64
-	Amount        float64
+	Amount        tonapi.Amount
+	Currency      string // TON or a jetton symbol
 	SenderAddress string
 }
 
-// PendingPremiumPayment for tracking unique payment amounts
-type PendingPremiumPayment struct {
-	UserID       int64
-	UniqueAmount float64
-	CreatedAt    time.Time
+// InvoiceStatus is the payment state of a premium invoice
+type InvoiceStatus string
+
+const (
+	InvoiceOpen      InvoiceStatus = "open"
+	InvoicePaid      InvoiceStatus = "paid"
+	InvoiceUnderpaid InvoiceStatus = "underpaid" // part received, waiting for the rest
+	InvoiceExpired   InvoiceStatus = "expired"
+)
+
+// PremiumInvoice is a premium purchase waiting for payment. The memo always
+// identifies the invoice, the amount does until it is paid or released after expiry.
+type PremiumInvoice struct {
+	ID         int64
+	UserID     int64
+	Plan       string
+	Currency   string        // TON or a jetton symbol
+	Amount     tonapi.Amount // price plus a unique suffix
+	Memo       string
+	Status     InvoiceStatus
+	PaidAmount tonapi.Amount
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// Remaining returns how much is left to pay
+func (i *PremiumInvoice) Remaining() tonapi.Amount {
+	left := i.Amount.Sub(i.PaidAmount)
+	if left.Units.Sign() < 0 {
+		left.Units = new(big.Int)
+	}
+	return left
+}
+
+// AppliedPremiumPayment is the result of ApplyPremiumPayment
+type AppliedPremiumPayment struct {
+	Invoice   *PremiumInvoice // invoice after the payment, nil without one
+	ExpiresAt time.Time       // new premium expiry, zero while the invoice is underpaid
+}
+
+// OutboxStatus is the delivery state of a queued notification
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "pending"
+	OutboxSent    OutboxStatus = "sent"
+	OutboxDead    OutboxStatus = "dead" // gave up after too many attempts
+)
+
+// OutboxMessage is a notification waiting for delivery to Telegram
+type OutboxMessage struct {
+	ID            int64
+	UserID        int64
+	Text          string
+	Status        OutboxStatus
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
 }
@@ -1,103 +1,188 @@
 package storage
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"errors"
-	"math"
+	"fmt"
+	"math/big"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/suspectuso/ton-tracker/internal/metrics"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
 )
 
 var (
 	ErrNotFound      = errors.New("not found")
 	ErrLimitReached  = errors.New("wallet limit reached")
 	ErrAlreadyExists = errors.New("already exists")
+	ErrNoFreeAmount  = errors.New("no free invoice amount")
 )
 
-// Storage handles all database operations
+// Store is the persistence layer used by the bot
+type Store interface {
+	// Wallets
+	AddWallet(userID int64, name, addressRaw, addressDisplay string, maxWallets int) (*Wallet, error)
+	ListWallets(userID int64) ([]Wallet, error)
+	GetWallet(walletID int64) (*Wallet, error)
+	GetWalletsByRaw(addressRaw string) ([]Wallet, error)
+	GetAllWallets() ([]Wallet, error)
+	GetWalletCount(userID int64) (int, error)
+	RemoveWallet(userID, walletID int64) error
+	PauseExcessWallets(userID int64, keep int) (int, error)
+	ResumeWallets(userID int64, limit int) (int, error)
+	SetWalletMinAmount(userID, walletID int64, amount float64) error
+	ToggleWalletNotify(userID, walletID int64, category NotifyCategory) error
+	ResetWalletFilters(userID, walletID int64) error
+	WalletChanges() <-chan WalletChange
+
+	// Processed events
+	MarkEventProcessed(walletID int64, eventID string) (bool, error)
+	ListAccountCursors() (map[string]int64, error)
+	GetAccountCursor(addressRaw string) (int64, error)
+	AdvanceAccountCursor(addressRaw string, lt int64) error
+
+	// Notification outbox
+	EnqueueNotification(userID int64, text string) error
+	ClaimDueNotifications(limit int, lease time.Duration) ([]OutboxMessage, error)
+	MarkNotificationSent(id int64) error
+	RetryNotification(id int64, attempts int, next time.Time, lastErr string) error
+	MarkNotificationDead(id int64, attempts int, lastErr string) error
+	PruneSentNotifications(before time.Time) (int64, error)
+
+	// Premium
+	IsPremium(userID int64) bool
+	GetPremium(userID int64) (*PremiumUser, error)
+	ExtendPremium(userID int64, plan string, period time.Duration, payerAddress, eventID string) (time.Time, error)
+	ListPremiumToRemind(before time.Time) ([]PremiumUser, error)
+	MarkPremiumReminded(userID int64) error
+	ListExpiredPremium() ([]PremiumUser, error)
+	MarkPremiumDowngraded(userID int64) error
+	MarkPremiumPayment(eventID string, userID int64, amount tonapi.Amount, currency, sender string) (bool, error)
+	ApplyPremiumPayment(payment PremiumPayment, invoiceID int64, plan string, period time.Duration) (*AppliedPremiumPayment, error)
+
+	// Premium invoices
+	CreatePremiumInvoice(userID int64, plan, currency string, price tonapi.Amount, ttl time.Duration) (*PremiumInvoice, error)
+	GetPremiumInvoiceByMemo(memo string) (*PremiumInvoice, error)
+	FindPremiumInvoiceByAmount(currency string, amount tonapi.Amount) (*PremiumInvoice, error)
+	LatestPremiumInvoice(userID int64) (*PremiumInvoice, error)
+	AddPremiumInvoicePayment(id int64, amount tonapi.Amount) (*PremiumInvoice, error)
+	ExpirePremiumInvoices() (int64, error)
+
+	// Schema
+	Migrate() (int, error)
+	SchemaVersion() (int, error)
+	Close() error
+}
+
+// Storage is a Store backed by SQLite or PostgreSQL
 type Storage struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+	changes chan WalletChange
 }
 
-// New creates a new Storage instance and initializes the database
-func New(dbPath string) (*Storage, error) {
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
+// walletChangesBuffer is how many wallet changes are kept for a slow reader
+const walletChangesBuffer = 256
+
+var _ Store = (*Storage)(nil)
+
+// New opens the database and applies pending migrations.
+// driver is "sqlite" (dsn is a file path) or "postgres" (dsn is a connection URL).
+func New(driver, dsn string) (*Storage, error) {
+	s, err := Open(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	s := &Storage{db: db}
-	if err := s.init(); err != nil {
-		db.Close()
+	if _, err := s.Migrate(); err != nil {
+		s.Close()
 		return nil, err
 	}
 
 	return s, nil
 }
 
+// Open opens the database without applying migrations
+func Open(driver, dsn string) (*Storage, error) {
+	d, err := dialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(d.driver, d.dataSource(dsn))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Storage{
+		db:      db,
+		dialect: d,
+		changes: make(chan WalletChange, walletChangesBuffer),
+	}, nil
+}
+
 // Close closes the database connection
 func (s *Storage) Close() error {
 	return s.db.Close()
 }
 
-func (s *Storage) init() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS wallets (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER NOT NULL,
-			name TEXT NOT NULL,
-			address_raw TEXT NOT NULL,
-			address_display TEXT NOT NULL,
-			min_amount_ton REAL,
-			created_at INTEGER NOT NULL
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_wallets_user_id ON wallets(user_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_wallets_address_raw ON wallets(address_raw)`,
-
-		`CREATE TABLE IF NOT EXISTS processed_events (
-			wallet_id INTEGER NOT NULL,
-			event_id TEXT NOT NULL,
-			PRIMARY KEY (wallet_id, event_id)
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS premium_users (
-			user_id INTEGER PRIMARY KEY,
-			activated_at INTEGER NOT NULL,
-			payer_address TEXT,
-			event_id TEXT
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS premium_payments (
-			event_id TEXT PRIMARY KEY,
-			user_id INTEGER,
-			amount REAL,
-			sender_address TEXT
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS pending_premium_payments (
-			user_id INTEGER PRIMARY KEY,
-			unique_amount REAL NOT NULL,
-			created_at INTEGER NOT NULL
-		)`,
-	}
-
-	for _, q := range queries {
-		if _, err := s.db.Exec(q); err != nil {
-			return err
-		}
+// --- Wallets ---
+
+const walletColumns = "id, user_id, name, address_raw, address_display, min_amount_ton, created_at, notify_categories, paused_at"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWallet(row rowScanner) (*Wallet, error) {
+	var w Wallet
+	var createdAt int64
+	var minAmount sql.NullFloat64
+	var pausedAt sql.NullInt64
+
+	err := row.Scan(&w.ID, &w.UserID, &w.Name, &w.AddressRaw, &w.AddressDisplay, &minAmount, &createdAt, &w.Notify, &pausedAt)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	w.Paused = pausedAt.Valid
+	w.CreatedAt = time.Unix(createdAt, 0)
+	if minAmount.Valid {
+		w.MinAmountTON = &minAmount.Float64
+	}
+
+	return &w, nil
 }
 
-// --- Wallets ---
+func (s *Storage) queryWallets(query string, args ...interface{}) ([]Wallet, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var wallets []Wallet
+	for rows.Next() {
+		w, err := scanWallet(rows)
+		if err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, *w)
+	}
+
+	return wallets, rows.Err()
+}
 
 // AddWallet adds a new wallet for a user
 func (s *Storage) AddWallet(userID int64, name, addressRaw, addressDisplay string, maxWallets int) (*Wallet, error) {
-	// Check current wallet count
-	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM wallets WHERE user_id = ?", userID).Scan(&count)
+	// Check current wallet count, paused wallets don't count
+	count, err := s.GetWalletCount(userID)
 	if err != nil {
 		return nil, err
 	}
@@ -107,16 +192,18 @@ func (s *Storage) AddWallet(userID int64, name, addressRaw, addressDisplay strin
 	}
 
 	now := time.Now().Unix()
-	result, err := s.db.Exec(
-		`INSERT INTO wallets (user_id, name, address_raw, address_display, created_at)
-		 VALUES (?, ?, ?, ?, ?)`,
-		userID, name, addressRaw, addressDisplay, now,
-	)
+	var id int64
+	err = s.queryRow(
+		`INSERT INTO wallets (user_id, name, address_raw, address_display, created_at, notify_categories)
+		 VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		userID, name, addressRaw, addressDisplay, now, NotifyDefault,
+	).Scan(&id)
 	if err != nil {
 		return nil, err
 	}
 
-	id, _ := result.LastInsertId()
+	s.publish(WalletChange{AddressRaw: addressRaw})
+
 	return &Wallet{
 		ID:             id,
 		UserID:         userID,
@@ -124,54 +211,24 @@ func (s *Storage) AddWallet(userID int64, name, addressRaw, addressDisplay strin
 		AddressRaw:     addressRaw,
 		AddressDisplay: addressDisplay,
 		CreatedAt:      time.Unix(now, 0),
+		Notify:         NotifyDefault,
 	}, nil
 }
 
 // ListWallets returns all wallets for a user
 func (s *Storage) ListWallets(userID int64) ([]Wallet, error) {
-	rows, err := s.db.Query(
-		`SELECT id, user_id, name, address_raw, address_display, min_amount_ton, created_at
-		 FROM wallets WHERE user_id = ? ORDER BY id DESC`,
+	return s.queryWallets(
+		"SELECT "+walletColumns+" FROM wallets WHERE user_id = ? ORDER BY id DESC",
 		userID,
 	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var wallets []Wallet
-	for rows.Next() {
-		var w Wallet
-		var createdAt int64
-		var minAmount sql.NullFloat64
-
-		err := rows.Scan(&w.ID, &w.UserID, &w.Name, &w.AddressRaw, &w.AddressDisplay, &minAmount, &createdAt)
-		if err != nil {
-			return nil, err
-		}
-
-		w.CreatedAt = time.Unix(createdAt, 0)
-		if minAmount.Valid {
-			w.MinAmountTON = &minAmount.Float64
-		}
-		wallets = append(wallets, w)
-	}
-
-	return wallets, nil
 }
 
 // GetWallet returns a wallet by ID
 func (s *Storage) GetWallet(walletID int64) (*Wallet, error) {
-	var w Wallet
-	var createdAt int64
-	var minAmount sql.NullFloat64
-
-	err := s.db.QueryRow(
-		`SELECT id, user_id, name, address_raw, address_display, min_amount_ton, created_at
-		 FROM wallets WHERE id = ?`,
+	w, err := scanWallet(s.queryRow(
+		"SELECT "+walletColumns+" FROM wallets WHERE id = ?",
 		walletID,
-	).Scan(&w.ID, &w.UserID, &w.Name, &w.AddressRaw, &w.AddressDisplay, &minAmount, &createdAt)
-
+	))
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
@@ -179,97 +236,134 @@ func (s *Storage) GetWallet(walletID int64) (*Wallet, error) {
 		return nil, err
 	}
 
-	w.CreatedAt = time.Unix(createdAt, 0)
-	if minAmount.Valid {
-		w.MinAmountTON = &minAmount.Float64
-	}
-
-	return &w, nil
+	return w, nil
 }
 
-// GetWalletsByRaw returns all wallets with a specific raw address
+// GetWalletsByRaw returns all tracked (not paused) wallets with a specific raw address
 func (s *Storage) GetWalletsByRaw(addressRaw string) ([]Wallet, error) {
-	rows, err := s.db.Query(
-		`SELECT id, user_id, name, address_raw, address_display, min_amount_ton, created_at
-		 FROM wallets WHERE address_raw = ?`,
+	return s.queryWallets(
+		"SELECT "+walletColumns+" FROM wallets WHERE address_raw = ? AND paused_at IS NULL",
 		addressRaw,
 	)
+}
+
+// GetAllWallets returns all tracked (not paused) wallets in the database
+func (s *Storage) GetAllWallets() ([]Wallet, error) {
+	return s.queryWallets("SELECT " + walletColumns + " FROM wallets WHERE paused_at IS NULL")
+}
+
+// RemoveWallet removes a wallet
+func (s *Storage) RemoveWallet(userID, walletID int64) error {
+	var addressRaw string
+	err := s.queryRow(
+		"DELETE FROM wallets WHERE user_id = ? AND id = ? RETURNING address_raw",
+		userID, walletID,
+	).Scan(&addressRaw)
+	if err == sql.ErrNoRows {
+		return nil
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var wallets []Wallet
-	for rows.Next() {
-		var w Wallet
-		var createdAt int64
-		var minAmount sql.NullFloat64
+	s.publish(WalletChange{AddressRaw: addressRaw, Removed: true})
 
-		err := rows.Scan(&w.ID, &w.UserID, &w.Name, &w.AddressRaw, &w.AddressDisplay, &minAmount, &createdAt)
-		if err != nil {
-			return nil, err
-		}
+	// Also remove processed events
+	_, err = s.exec("DELETE FROM processed_events WHERE wallet_id = ?", walletID)
+	return err
+}
 
-		w.CreatedAt = time.Unix(createdAt, 0)
-		if minAmount.Valid {
-			w.MinAmountTON = &minAmount.Float64
-		}
-		wallets = append(wallets, w)
+// PauseExcessWallets pauses the user's newest wallets beyond keep, returns how many were paused
+func (s *Storage) PauseExcessWallets(userID int64, keep int) (int, error) {
+	return s.updateWalletsPaused(
+		`UPDATE wallets SET paused_at = ?
+		 WHERE user_id = ? AND paused_at IS NULL AND id NOT IN (
+			SELECT id FROM wallets WHERE user_id = ? AND paused_at IS NULL ORDER BY id LIMIT ?
+		 ) RETURNING address_raw`,
+		true, time.Now().Unix(), userID, userID, keep,
+	)
+}
+
+// ResumeWallets resumes the user's oldest paused wallets while fewer than limit are active,
+// returns how many were resumed
+func (s *Storage) ResumeWallets(userID int64, limit int) (int, error) {
+	active, err := s.GetWalletCount(userID)
+	if err != nil {
+		return 0, err
+	}
+	if active >= limit {
+		return 0, nil
 	}
 
-	return wallets, nil
+	return s.updateWalletsPaused(
+		`UPDATE wallets SET paused_at = NULL
+		 WHERE id IN (
+			SELECT id FROM wallets WHERE user_id = ? AND paused_at IS NOT NULL ORDER BY id LIMIT ?
+		 ) RETURNING address_raw`,
+		false, userID, limit-active,
+	)
 }
 
-// GetAllWallets returns all wallets in the database
-func (s *Storage) GetAllWallets() ([]Wallet, error) {
-	rows, err := s.db.Query(
-		`SELECT id, user_id, name, address_raw, address_display, min_amount_ton, created_at
-		 FROM wallets`,
-	)
+// updateWalletsPaused runs a pause/resume update returning address_raw and publishes the changes
+func (s *Storage) updateWalletsPaused(query string, paused bool, args ...interface{}) (int, error) {
+	rows, err := s.query(query, args...)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer rows.Close()
 
-	var wallets []Wallet
+	var addresses []string
 	for rows.Next() {
-		var w Wallet
-		var createdAt int64
-		var minAmount sql.NullFloat64
-
-		err := rows.Scan(&w.ID, &w.UserID, &w.Name, &w.AddressRaw, &w.AddressDisplay, &minAmount, &createdAt)
-		if err != nil {
-			return nil, err
+		var addr string
+		if err := rows.Scan(&addr); err != nil {
+			return 0, err
 		}
+		addresses = append(addresses, addr)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
 
-		w.CreatedAt = time.Unix(createdAt, 0)
-		if minAmount.Valid {
-			w.MinAmountTON = &minAmount.Float64
-		}
-		wallets = append(wallets, w)
+	for _, addr := range addresses {
+		s.publish(WalletChange{AddressRaw: addr, Removed: paused})
 	}
+	return len(addresses), nil
+}
 
-	return wallets, nil
+// WalletChanges returns wallet additions and removals for a single reader.
+// When the reader falls behind, changes are dropped and a Resync change is
+// queued in their place.
+func (s *Storage) WalletChanges() <-chan WalletChange {
+	return s.changes
 }
 
-// RemoveWallet removes a wallet
-func (s *Storage) RemoveWallet(userID, walletID int64) error {
-	_, err := s.db.Exec(
-		"DELETE FROM wallets WHERE user_id = ? AND id = ?",
-		userID, walletID,
-	)
-	if err != nil {
-		return err
+func (s *Storage) publish(change WalletChange) {
+	select {
+	case s.changes <- change:
+		return
+	default:
 	}
 
-	// Also remove processed events
-	_, err = s.db.Exec("DELETE FROM processed_events WHERE wallet_id = ?", walletID)
-	return err
+	// Full: make room for a resync marker by dropping the oldest changes,
+	// the reader then syncs everything from the DB
+	metrics.WalletChangesDropped.Inc()
+	for {
+		select {
+		case s.changes <- WalletChange{Resync: true}:
+			return
+		default:
+		}
+		select {
+		case <-s.changes:
+			metrics.WalletChangesDropped.Inc()
+		default:
+		}
+	}
 }
 
 // SetWalletMinAmount sets the minimum amount filter for a wallet
 func (s *Storage) SetWalletMinAmount(userID, walletID int64, amount float64) error {
-	result, err := s.db.Exec(
+	result, err := s.exec(
 		"UPDATE wallets SET min_amount_ton = ? WHERE id = ? AND user_id = ?",
 		amount, walletID, userID,
 	)
@@ -284,11 +378,30 @@ func (s *Storage) SetWalletMinAmount(userID, walletID int64, amount float64) err
 	return nil
 }
 
+// ToggleWalletNotify flips a notification category for a wallet
+func (s *Storage) ToggleWalletNotify(userID, walletID int64, category NotifyCategory) error {
+	result, err := s.exec(
+		// SQLite has no XOR operator
+		`UPDATE wallets SET notify_categories = (notify_categories | ?) - (notify_categories & ?)
+		 WHERE id = ? AND user_id = ?`,
+		category, category, walletID, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 // ResetWalletFilters resets all filters for a wallet
 func (s *Storage) ResetWalletFilters(userID, walletID int64) error {
-	result, err := s.db.Exec(
-		"UPDATE wallets SET min_amount_ton = NULL WHERE id = ? AND user_id = ?",
-		walletID, userID,
+	result, err := s.exec(
+		"UPDATE wallets SET min_amount_ton = NULL, notify_categories = ? WHERE id = ? AND user_id = ?",
+		NotifyDefault, walletID, userID,
 	)
 	if err != nil {
 		return err
@@ -305,8 +418,9 @@ func (s *Storage) ResetWalletFilters(userID, walletID int64) error {
 
 // MarkEventProcessed marks an event as processed, returns true if it was new
 func (s *Storage) MarkEventProcessed(walletID int64, eventID string) (bool, error) {
-	_, err := s.db.Exec(
-		"INSERT OR IGNORE INTO processed_events (wallet_id, event_id) VALUES (?, ?)",
+	result, err := s.exec(
+		`INSERT INTO processed_events (wallet_id, event_id) VALUES (?, ?)
+		 ON CONFLICT DO NOTHING`,
 		walletID, eventID,
 	)
 	if err != nil {
@@ -314,50 +428,305 @@ func (s *Storage) MarkEventProcessed(walletID int64, eventID string) (bool, erro
 	}
 
 	// Check if it was actually inserted
-	var count int
-	err = s.db.QueryRow(
-		"SELECT changes()",
-	).Scan(&count)
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return false, err
 	}
 
-	return count > 0, nil
+	return rows > 0, nil
+}
+
+// ListAccountCursors returns the last processed logical time per address
+func (s *Storage) ListAccountCursors() (map[string]int64, error) {
+	rows, err := s.query("SELECT address_raw, last_lt FROM account_cursors")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cursors := make(map[string]int64)
+	for rows.Next() {
+		var addr string
+		var lt int64
+		if err := rows.Scan(&addr, &lt); err != nil {
+			return nil, err
+		}
+		cursors[addr] = lt
+	}
+
+	return cursors, rows.Err()
+}
+
+// GetAccountCursor returns the last processed logical time for an address, 0 if none
+func (s *Storage) GetAccountCursor(addressRaw string) (int64, error) {
+	var lt int64
+	err := s.queryRow(
+		"SELECT last_lt FROM account_cursors WHERE address_raw = ?",
+		addressRaw,
+	).Scan(&lt)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return lt, err
+}
+
+// AdvanceAccountCursor moves the address cursor forward, older lt values are ignored
+func (s *Storage) AdvanceAccountCursor(addressRaw string, lt int64) error {
+	_, err := s.exec(
+		`INSERT INTO account_cursors (address_raw, last_lt, updated_at) VALUES (?, ?, ?)
+		 ON CONFLICT(address_raw) DO UPDATE SET
+			last_lt = excluded.last_lt,
+			updated_at = excluded.updated_at
+		 WHERE account_cursors.last_lt < excluded.last_lt`,
+		addressRaw, lt, time.Now().Unix(),
+	)
+	return err
+}
+
+// --- Notification Outbox ---
+
+// EnqueueNotification queues a notification for immediate delivery
+func (s *Storage) EnqueueNotification(userID int64, text string) error {
+	now := time.Now().Unix()
+	_, err := s.exec(
+		`INSERT INTO notification_outbox (user_id, text, status, next_attempt_at, created_at)
+		 VALUES (?, ?, ?, ?, ?)`,
+		userID, text, OutboxPending, now, now,
+	)
+	return err
+}
+
+// ClaimDueNotifications returns pending notifications whose next attempt is due
+// and postpones them by lease, so a crashed or concurrent worker
+// doesn't deliver the same message twice while it's in flight
+func (s *Storage) ClaimDueNotifications(limit int, lease time.Duration) ([]OutboxMessage, error) {
+	now := time.Now()
+	rows, err := s.query(
+		`SELECT id, user_id, text, status, attempts, next_attempt_at, COALESCE(last_error, ''), created_at
+		 FROM notification_outbox
+		 WHERE status = ? AND next_attempt_at <= ?
+		 ORDER BY id LIMIT ?`,
+		OutboxPending, now.Unix(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var due []OutboxMessage
+	for rows.Next() {
+		var m OutboxMessage
+		var nextAttempt, createdAt int64
+		if err := rows.Scan(&m.ID, &m.UserID, &m.Text, &m.Status, &m.Attempts, &nextAttempt, &m.LastError, &createdAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.NextAttemptAt = time.Unix(nextAttempt, 0)
+		m.CreatedAt = time.Unix(createdAt, 0)
+		due = append(due, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	leaseUntil := now.Add(lease).Unix()
+	var claimed []OutboxMessage
+	for _, m := range due {
+		result, err := s.exec(
+			`UPDATE notification_outbox SET next_attempt_at = ?
+			 WHERE id = ? AND status = ? AND next_attempt_at = ?`,
+			leaseUntil, m.ID, OutboxPending, m.NextAttemptAt.Unix(),
+		)
+		if err != nil {
+			return claimed, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			claimed = append(claimed, m)
+		}
+	}
+
+	return claimed, nil
+}
+
+// MarkNotificationSent marks a notification as delivered
+func (s *Storage) MarkNotificationSent(id int64) error {
+	_, err := s.exec(
+		"UPDATE notification_outbox SET status = ?, attempts = attempts + 1, sent_at = ? WHERE id = ?",
+		OutboxSent, time.Now().Unix(), id,
+	)
+	return err
+}
+
+// RetryNotification records a failed attempt and schedules the next one
+func (s *Storage) RetryNotification(id int64, attempts int, next time.Time, lastErr string) error {
+	_, err := s.exec(
+		"UPDATE notification_outbox SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?",
+		attempts, next.Unix(), lastErr, id,
+	)
+	return err
+}
+
+// MarkNotificationDead stops retrying a notification, it stays in the table for inspection
+func (s *Storage) MarkNotificationDead(id int64, attempts int, lastErr string) error {
+	_, err := s.exec(
+		"UPDATE notification_outbox SET status = ?, attempts = ?, last_error = ? WHERE id = ?",
+		OutboxDead, attempts, lastErr, id,
+	)
+	return err
+}
+
+// PruneSentNotifications deletes delivered notifications older than before
+func (s *Storage) PruneSentNotifications(before time.Time) (int64, error) {
+	result, err := s.exec(
+		"DELETE FROM notification_outbox WHERE status = ? AND sent_at < ?",
+		OutboxSent, before.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 // --- Premium ---
 
-// IsPremium checks if a user has premium
+const premiumColumns = "user_id, activated_at, expires_at, plan, payer_address, event_id"
+
+func scanPremiumUser(row rowScanner) (*PremiumUser, error) {
+	var p PremiumUser
+	var activatedAt, expiresAt int64
+	var payer, eventID sql.NullString
+
+	if err := row.Scan(&p.UserID, &activatedAt, &expiresAt, &p.Plan, &payer, &eventID); err != nil {
+		return nil, err
+	}
+
+	p.ActivatedAt = time.Unix(activatedAt, 0)
+	p.ExpiresAt = time.Unix(expiresAt, 0)
+	p.PayerAddress = payer.String
+	p.EventID = eventID.String
+	return &p, nil
+}
+
+func (s *Storage) queryPremiumUsers(query string, args ...interface{}) ([]PremiumUser, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []PremiumUser
+	for rows.Next() {
+		p, err := scanPremiumUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *p)
+	}
+
+	return users, rows.Err()
+}
+
+// IsPremium checks if a user has unexpired premium
 func (s *Storage) IsPremium(userID int64) bool {
 	var count int
-	err := s.db.QueryRow(
-		"SELECT 1 FROM premium_users WHERE user_id = ?",
-		userID,
+	err := s.queryRow(
+		"SELECT 1 FROM premium_users WHERE user_id = ? AND expires_at > ?",
+		userID, time.Now().Unix(),
 	).Scan(&count)
 	return err == nil
 }
 
-// ActivatePremium activates premium for a user
-func (s *Storage) ActivatePremium(userID int64, payerAddress, eventID string) error {
+// GetPremium returns the premium record of a user, expired or not
+func (s *Storage) GetPremium(userID int64) (*PremiumUser, error) {
+	p, err := scanPremiumUser(s.queryRow(
+		"SELECT "+premiumColumns+" FROM premium_users WHERE user_id = ?",
+		userID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return p, err
+}
+
+// ExtendPremium adds a paid period to a user's premium, on top of the remaining
+// time if it hasn't expired yet. Returns the new expiry.
+func (s *Storage) ExtendPremium(userID int64, plan string, period time.Duration, payerAddress, eventID string) (time.Time, error) {
+	return s.extendPremium(s.db, userID, plan, period, payerAddress, eventID)
+}
+
+func (s *Storage) extendPremium(q querier, userID int64, plan string, period time.Duration, payerAddress, eventID string) (time.Time, error) {
 	now := time.Now().Unix()
-	_, err := s.db.Exec(
-		`INSERT INTO premium_users (user_id, activated_at, payer_address, event_id)
-		 VALUES (?, ?, ?, ?)
+	seconds := int64(period / time.Second)
+
+	var expiresAt int64
+	err := q.QueryRow(s.dialect.rebind(
+		`INSERT INTO premium_users (user_id, activated_at, expires_at, plan, payer_address, event_id)
+		 VALUES (?, ?, ?, ?, ?, ?)
 		 ON CONFLICT(user_id) DO UPDATE SET
 			activated_at = excluded.activated_at,
+			expires_at = CASE WHEN premium_users.expires_at > ?
+				THEN premium_users.expires_at + ? ELSE excluded.expires_at END,
+			plan = excluded.plan,
 			payer_address = excluded.payer_address,
-			event_id = excluded.event_id`,
-		userID, now, payerAddress, eventID,
+			event_id = excluded.event_id,
+			reminded_at = NULL,
+			downgraded_at = NULL
+		 RETURNING expires_at`),
+		userID, now, now+seconds, plan, payerAddress, eventID, now, seconds,
+	).Scan(&expiresAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(expiresAt, 0), nil
+}
+
+// ListPremiumToRemind returns active premium users expiring before the given time
+// who haven't been reminded about it yet
+func (s *Storage) ListPremiumToRemind(before time.Time) ([]PremiumUser, error) {
+	return s.queryPremiumUsers(
+		"SELECT "+premiumColumns+" FROM premium_users WHERE expires_at > ? AND expires_at <= ? AND reminded_at IS NULL",
+		time.Now().Unix(), before.Unix(),
+	)
+}
+
+// MarkPremiumReminded records that the expiry reminder was sent
+func (s *Storage) MarkPremiumReminded(userID int64) error {
+	_, err := s.exec(
+		"UPDATE premium_users SET reminded_at = ? WHERE user_id = ?",
+		time.Now().Unix(), userID,
+	)
+	return err
+}
+
+// ListExpiredPremium returns users whose premium expired and who weren't downgraded yet
+func (s *Storage) ListExpiredPremium() ([]PremiumUser, error) {
+	return s.queryPremiumUsers(
+		"SELECT "+premiumColumns+" FROM premium_users WHERE expires_at <= ? AND downgraded_at IS NULL",
+		time.Now().Unix(),
+	)
+}
+
+// MarkPremiumDowngraded records that an expired user was moved back to the free limit
+func (s *Storage) MarkPremiumDowngraded(userID int64) error {
+	_, err := s.exec(
+		"UPDATE premium_users SET downgraded_at = ? WHERE user_id = ?",
+		time.Now().Unix(), userID,
 	)
 	return err
 }
 
-// MarkPremiumPayment records a premium payment, returns true if new
-func (s *Storage) MarkPremiumPayment(eventID string, userID int64, amount float64, sender string) (bool, error) {
-	result, err := s.db.Exec(
-		`INSERT OR IGNORE INTO premium_payments (event_id, user_id, amount, sender_address)
-		 VALUES (?, ?, ?, ?)`,
-		eventID, userID, amount, sender,
+// MarkPremiumPayment records a premium payment with its exact amount, returns true if new
+func (s *Storage) MarkPremiumPayment(eventID string, userID int64, amount tonapi.Amount, currency, sender string) (bool, error) {
+	return s.markPremiumPayment(s.db, eventID, userID, amount, currency, sender)
+}
+
+func (s *Storage) markPremiumPayment(q querier, eventID string, userID int64, amount tonapi.Amount, currency, sender string) (bool, error) {
+	result, err := q.Exec(s.dialect.rebind(
+		`INSERT INTO premium_payments (event_id, user_id, amount, currency, sender_address)
+		 VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT DO NOTHING`),
+		eventID, userID, amount.String(), currency, sender,
 	)
 	if err != nil {
 		return false, err
@@ -367,54 +736,320 @@ func (s *Storage) MarkPremiumPayment(eventID string, userID int64, amount float6
 	return rows > 0, nil
 }
 
-// RegisterPendingPremium registers a pending premium payment
-func (s *Storage) RegisterPendingPremium(userID int64, uniqueAmount float64) error {
-	now := time.Now().Unix()
-	_, err := s.db.Exec(
-		`INSERT OR REPLACE INTO pending_premium_payments (user_id, unique_amount, created_at)
-		 VALUES (?, ?, ?)`,
-		userID, uniqueAmount, now,
+// ApplyPremiumPayment records a payment, adds it to invoice invoiceID (0 for
+// none) and extends premium by plan once the invoice is paid, all in one
+// transaction: a failure leaves the payment unrecorded to be applied again.
+// Returns nil if the payment was already recorded.
+func (s *Storage) ApplyPremiumPayment(payment PremiumPayment, invoiceID int64, plan string, period time.Duration) (*AppliedPremiumPayment, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	isNew, err := s.markPremiumPayment(tx, payment.EventID, payment.UserID, payment.Amount, payment.Currency, payment.SenderAddress)
+	if err != nil || !isNew {
+		return nil, err
+	}
+
+	applied := &AppliedPremiumPayment{}
+	if invoiceID != 0 {
+		applied.Invoice, err = s.addPremiumInvoicePayment(tx, invoiceID, payment.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if applied.Invoice == nil || applied.Invoice.Status == InvoicePaid {
+		applied.ExpiresAt, err = s.extendPremium(tx, payment.UserID, plan, period, payment.SenderAddress, payment.EventID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return applied, tx.Commit()
+}
+
+// --- Premium invoices ---
+
+const invoiceColumns = "id, user_id, plan, currency, amount, decimals, memo, status, paid_amount, created_at, expires_at"
+
+const (
+	// invoiceSuffixes is the number of unique amounts per price: price + 0.0001 ... price + 0.0999
+	invoiceSuffixes = 999
+
+	// invoiceAttempts is how many random amounts are tried before giving up
+	invoiceAttempts = 20
+
+	// invoiceReserveGrace keeps the amount of an expired or underpaid invoice
+	// reserved after its deadline, so that a late payment still matches it
+	invoiceReserveGrace = 24 * time.Hour
+
+	// invoiceMemoAlphabet has no 0/O and 1/I to make memos easy to retype
+	invoiceMemoAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+	// InvoiceMemoPrefix starts every invoice memo, followed by 8 memo characters
+	InvoiceMemoPrefix = "TT"
+)
+
+func scanInvoice(row rowScanner) (*PremiumInvoice, error) {
+	var inv PremiumInvoice
+	var amount, paidAmount string
+	var decimals int
+	var createdAt, expiresAt int64
+
+	err := row.Scan(&inv.ID, &inv.UserID, &inv.Plan, &inv.Currency, &amount, &decimals, &inv.Memo,
+		&inv.Status, &paidAmount, &createdAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	inv.Amount = tonapi.JettonAmount(amount, decimals)
+	inv.PaidAmount = tonapi.JettonAmount(paidAmount, decimals)
+	inv.CreatedAt = time.Unix(createdAt, 0)
+	inv.ExpiresAt = time.Unix(expiresAt, 0)
+	return &inv, nil
+}
+
+// invoiceSuffixStep returns the unit of the unique suffix: 0.0001 of a token,
+// or the smallest unit for tokens with fewer decimals
+func invoiceSuffixStep(decimals int) *big.Int {
+	if decimals < 4 {
+		return big.NewInt(1)
+	}
+	return tonapi.Pow10(decimals - 4)
+}
+
+// CreatePremiumInvoice returns an open invoice for a plan, reusing the user's
+// open invoice for the same plan and price. A new invoice gets the price plus
+// a random suffix that no other invoice in the currency has reserved.
+func (s *Storage) CreatePremiumInvoice(userID int64, plan, currency string, price tonapi.Amount, ttl time.Duration) (*PremiumInvoice, error) {
+	// Release the amounts of stale invoices first
+	if _, err := s.ExpirePremiumInvoices(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl).Unix()
+	step := invoiceSuffixStep(price.Decimals)
+
+	open, err := s.listOpenPremiumInvoices(userID, plan, currency)
+	if err != nil {
+		return nil, err
+	}
+	maxAmount := tonapi.Amount{
+		Units:    new(big.Int).Add(price.Units, new(big.Int).Mul(step, big.NewInt(invoiceSuffixes))),
+		Decimals: price.Decimals,
+	}
+	for _, inv := range open {
+		if inv.Amount.Decimals != price.Decimals || inv.Amount.Cmp(price) <= 0 || inv.Amount.Cmp(maxAmount) > 0 {
+			continue
+		}
+
+		reused, err := scanInvoice(s.queryRow(
+			"UPDATE premium_invoices SET expires_at = ? WHERE id = ? AND status = ? RETURNING "+invoiceColumns,
+			expiresAt, inv.ID, InvoiceOpen,
+		))
+		if err == sql.ErrNoRows {
+			// Paid or expired meanwhile
+			continue
+		}
+		return reused, err
+	}
+
+	for attempt := 0; attempt < invoiceAttempts; attempt++ {
+		suffix, err := randomInt(invoiceSuffixes)
+		if err != nil {
+			return nil, err
+		}
+		memo, err := newInvoiceMemo()
+		if err != nil {
+			return nil, err
+		}
+		amount := new(big.Int).Mul(step, big.NewInt(int64(suffix+1)))
+		amount.Add(amount, price.Units)
+
+		// A reserved amount or a taken memo hits a unique index and inserts nothing
+		inv, err := scanInvoice(s.queryRow(
+			`INSERT INTO premium_invoices (user_id, plan, currency, amount, decimals, memo, status, created_at, expires_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			 ON CONFLICT DO NOTHING
+			 RETURNING `+invoiceColumns,
+			userID, plan, currency, amount.String(), price.Decimals, memo, InvoiceOpen, now.Unix(), expiresAt,
+		))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		return inv, err
+	}
+
+	return nil, ErrNoFreeAmount
+}
+
+// listOpenPremiumInvoices returns the user's open invoices for a plan, newest first
+func (s *Storage) listOpenPremiumInvoices(userID int64, plan, currency string) ([]*PremiumInvoice, error) {
+	rows, err := s.query(
+		`SELECT `+invoiceColumns+` FROM premium_invoices
+		 WHERE user_id = ? AND plan = ? AND currency = ? AND status = ?
+		 ORDER BY created_at DESC, id DESC`,
+		userID, plan, currency, InvoiceOpen,
 	)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []*PremiumInvoice
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+	return invoices, rows.Err()
+}
+
+// GetPremiumInvoiceByMemo returns the invoice with the given memo, in any status
+func (s *Storage) GetPremiumInvoiceByMemo(memo string) (*PremiumInvoice, error) {
+	inv, err := scanInvoice(s.queryRow(
+		"SELECT "+invoiceColumns+" FROM premium_invoices WHERE memo = ?",
+		memo,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return inv, err
 }
 
-// GetUserByPremiumAmount finds a user by their unique payment amount
-func (s *Storage) GetUserByPremiumAmount(amount float64) (int64, error) {
-	var userID int64
-	err := s.db.QueryRow(
-		`SELECT user_id FROM pending_premium_payments
-		 WHERE ABS(unique_amount - ?) < 0.0001
-		 ORDER BY created_at DESC LIMIT 1`,
-		amount,
-	).Scan(&userID)
+// FindPremiumInvoiceByAmount returns the invoice that has the exact amount
+// reserved: an open or underpaid one, or one expired within the grace period
+func (s *Storage) FindPremiumInvoiceByAmount(currency string, amount tonapi.Amount) (*PremiumInvoice, error) {
+	if amount.Units == nil {
+		return nil, ErrNotFound
+	}
 
+	inv, err := scanInvoice(s.queryRow(
+		`SELECT `+invoiceColumns+` FROM premium_invoices
+		 WHERE currency = ? AND amount = ? AND decimals = ? AND amount_reserved = 1`,
+		currency, amount.Units.String(), amount.Decimals,
+	))
 	if err == sql.ErrNoRows {
-		return 0, ErrNotFound
+		return nil, ErrNotFound
 	}
-	return userID, err
+	return inv, err
 }
 
-// ClearPendingPremium removes a pending premium payment
-func (s *Storage) ClearPendingPremium(userID int64) error {
-	_, err := s.db.Exec(
-		"DELETE FROM pending_premium_payments WHERE user_id = ?",
+// LatestPremiumInvoice returns the most recent invoice of a user
+func (s *Storage) LatestPremiumInvoice(userID int64) (*PremiumInvoice, error) {
+	inv, err := scanInvoice(s.queryRow(
+		`SELECT `+invoiceColumns+` FROM premium_invoices
+		 WHERE user_id = ? ORDER BY created_at DESC, id DESC LIMIT 1`,
 		userID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return inv, err
+}
+
+// AddPremiumInvoicePayment adds a received amount to an unpaid invoice and
+// marks it paid once the full amount has arrived, underpaid otherwise.
+// Late payments for expired invoices are accepted too. The sum is exact, so
+// it is computed here and written only if no other payment changed it meanwhile.
+func (s *Storage) AddPremiumInvoicePayment(id int64, amount tonapi.Amount) (*PremiumInvoice, error) {
+	return s.addPremiumInvoicePayment(s.db, id, amount)
+}
+
+func (s *Storage) addPremiumInvoicePayment(q querier, id int64, amount tonapi.Amount) (*PremiumInvoice, error) {
+	for attempt := 0; attempt < invoiceAttempts; attempt++ {
+		inv, err := scanInvoice(q.QueryRow(s.dialect.rebind(
+			"SELECT "+invoiceColumns+" FROM premium_invoices WHERE id = ?"),
+			id,
+		))
+		if err == sql.ErrNoRows || (err == nil && inv.Status == InvoicePaid) {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if amount.Decimals != inv.Amount.Decimals {
+			return nil, fmt.Errorf("invoice %d has %d decimals, payment has %d", id, inv.Amount.Decimals, amount.Decimals)
+		}
+
+		paid := inv.PaidAmount.Add(amount)
+		set := "paid_amount = ?, status = ?"
+		args := []interface{}{paid.Units.String(), InvoiceUnderpaid}
+		if paid.Cmp(inv.Amount) >= 0 {
+			// A paid invoice releases its amount
+			set = "paid_amount = ?, status = ?, amount_reserved = 0, paid_at = ?"
+			args = []interface{}{paid.Units.String(), InvoicePaid, time.Now().Unix()}
+		}
+		args = append(args, id, inv.Status, inv.PaidAmount.Units.String())
+
+		updated, err := scanInvoice(q.QueryRow(s.dialect.rebind(
+			"UPDATE premium_invoices SET "+set+" WHERE id = ? AND status = ? AND paid_amount = ? RETURNING "+invoiceColumns),
+			args...,
+		))
+		if err == sql.ErrNoRows {
+			// Another payment for the same invoice came first
+			continue
+		}
+		return updated, err
+	}
+
+	return nil, fmt.Errorf("invoice %d: too many concurrent payments", id)
+}
+
+// ExpirePremiumInvoices expires open invoices past their deadline. Amounts of
+// unpaid invoices are released invoiceReserveGrace after the deadline.
+func (s *Storage) ExpirePremiumInvoices() (int64, error) {
+	now := time.Now()
+	result, err := s.exec(
+		"UPDATE premium_invoices SET status = ? WHERE status = ? AND expires_at <= ?",
+		InvoiceExpired, InvoiceOpen, now.Unix(),
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.exec(
+		"UPDATE premium_invoices SET amount_reserved = 0 WHERE amount_reserved = 1 AND expires_at <= ?",
+		now.Add(-invoiceReserveGrace).Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// newInvoiceMemo returns a random memo like TTK3J9QX2A
+func newInvoiceMemo() (string, error) {
+	memo := []byte(InvoiceMemoPrefix)
+	for i := 0; i < 8; i++ {
+		n, err := randomInt(len(invoiceMemoAlphabet))
+		if err != nil {
+			return "", err
+		}
+		memo = append(memo, invoiceMemoAlphabet[n])
+	}
+	return string(memo), nil
 }
 
-// GetWalletCount returns the number of wallets for a user
+func randomInt(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
+
+// GetWalletCount returns the number of active (not paused) wallets for a user
 func (s *Storage) GetWalletCount(userID int64) (int, error) {
 	var count int
-	err := s.db.QueryRow(
-		"SELECT COUNT(*) FROM wallets WHERE user_id = ?",
+	err := s.queryRow(
+		"SELECT COUNT(*) FROM wallets WHERE user_id = ? AND paused_at IS NULL",
 		userID,
 	).Scan(&count)
 	return count, err
 }
-
-// GenerateUniqueAmount generates a unique payment amount for a user
-func GenerateUniqueAmount(userID int64, basePrice float64) float64 {
-	suffix := float64(userID%1000) / 10000.0
-	return math.Round((basePrice+suffix)*10000) / 10000
-}
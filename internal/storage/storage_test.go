@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+// newTestStorage returns a migrated SQLite store in a temporary directory
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	s, err := New("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestNotifyCategoriesDefault(t *testing.T) {
+	s := newTestStorage(t)
+
+	// Rows that existed before the column was added get the column default
+	_, err := s.exec(
+		`INSERT INTO wallets (user_id, name, address_raw, address_display, created_at)
+		 VALUES (?, ?, ?, ?, ?)`,
+		1, "legacy", "0:legacy", "EQlegacy", 0,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wallets, err := s.ListWallets(1)
+	if err != nil || len(wallets) != 1 {
+		t.Fatalf("list wallets: %v %v", wallets, err)
+	}
+	if wallets[0].Notify != NotifyDefault {
+		t.Errorf("migration default = %d, want NotifyDefault %d", wallets[0].Notify, NotifyDefault)
+	}
+}
+
+func TestToggleWalletNotify(t *testing.T) {
+	s := newTestStorage(t)
+
+	w, err := s.AddWallet(1, "main", "0:main", "EQmain", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		toggle NotifyCategory
+		want   NotifyCategory
+	}{
+		{NotifyStaking, NotifyDefault &^ NotifyStaking},
+		{NotifyContractCalls, NotifyDefault&^NotifyStaking | NotifyContractCalls},
+		{NotifyStaking, NotifyDefault | NotifyContractCalls},
+	}
+
+	for i, tt := range tests {
+		if err := s.ToggleWalletNotify(1, w.ID, tt.toggle); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetWallet(w.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Notify != tt.want {
+			t.Errorf("step %d: notify = %b, want %b", i, got.Notify, tt.want)
+		}
+	}
+
+	if err := s.ResetWalletFilters(1, w.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetWallet(w.ID); got.Notify != NotifyDefault {
+		t.Errorf("after reset notify = %b, want %b", got.Notify, NotifyDefault)
+	}
+}
+
+func TestWalletChangesResync(t *testing.T) {
+	s := newTestStorage(t)
+
+	w, err := s.AddWallet(1, "main", "0:main", "EQmain", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change := <-s.WalletChanges(); change != (WalletChange{AddressRaw: "0:main"}) {
+		t.Fatalf("add published %+v", change)
+	}
+	if err := s.RemoveWallet(1, w.ID); err != nil {
+		t.Fatal(err)
+	}
+	if change := <-s.WalletChanges(); change != (WalletChange{AddressRaw: "0:main", Removed: true}) {
+		t.Fatalf("remove published %+v", change)
+	}
+
+	// A reader that fell behind gets a resync marker as the last change
+	for i := 0; i < walletChangesBuffer+10; i++ {
+		s.publish(WalletChange{AddressRaw: "0:main"})
+	}
+	var changes []WalletChange
+	for len(s.changes) > 0 {
+		changes = append(changes, <-s.changes)
+	}
+	if len(changes) != walletChangesBuffer {
+		t.Fatalf("%d changes queued, want %d", len(changes), walletChangesBuffer)
+	}
+	if !changes[len(changes)-1].Resync {
+		t.Errorf("last change %+v, want a resync", changes[len(changes)-1])
+	}
+}
+
+func TestNotificationOutbox(t *testing.T) {
+	s := newTestStorage(t)
+
+	for _, text := range []string{"first", "second", "third"} {
+		if err := s.EnqueueNotification(1, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimed, err := s.ClaimDueNotifications(10, time.Minute)
+	if err != nil || len(claimed) != 3 {
+		t.Fatalf("claim = %d messages (%v), want 3", len(claimed), err)
+	}
+	if claimed[0].Text != "first" || claimed[2].Text != "third" {
+		t.Errorf("claimed out of order: %+v", claimed)
+	}
+
+	// Leased messages are hidden from other workers
+	if again, _ := s.ClaimDueNotifications(10, time.Minute); len(again) != 0 {
+		t.Errorf("claimed %d leased messages", len(again))
+	}
+
+	if err := s.MarkNotificationSent(claimed[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RetryNotification(claimed[1].ID, 1, time.Now().Add(-time.Second), "timeout"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkNotificationDead(claimed[2].ID, 5, "blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := s.ClaimDueNotifications(10, time.Minute)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("claim after retry = %d messages (%v), want 1", len(due), err)
+	}
+	if due[0].ID != claimed[1].ID || due[0].Attempts != 1 || due[0].LastError != "timeout" {
+		t.Errorf("retried message = %+v", due[0])
+	}
+
+	pruned, err := s.PruneSentNotifications(time.Now().Add(time.Minute))
+	if err != nil || pruned != 1 {
+		t.Errorf("pruned %d (%v), want 1", pruned, err)
+	}
+}
+
+// setInvoiceDeadline moves an invoice deadline to simulate time passing
+func setInvoiceDeadline(t *testing.T, s *Storage, id int64, expiresAt time.Time) {
+	t.Helper()
+	if _, err := s.exec("UPDATE premium_invoices SET expires_at = ? WHERE id = ?", expiresAt.Unix(), id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreatePremiumInvoice(t *testing.T) {
+	s := newTestStorage(t)
+	price := tonapi.DecimalAmount(5, 9)
+	maxAmount := tonapi.DecimalAmount(5.0999, 9)
+
+	first, err := s.CreatePremiumInvoice(1, "month", "TON", price, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Amount.Cmp(price) <= 0 || first.Amount.Cmp(maxAmount) > 0 {
+		t.Errorf("amount = %s, want in (5, 5.0999]", first.Amount)
+	}
+	if step := tonapi.Pow10(5); new(big.Int).Rem(first.Amount.Units, step).Sign() != 0 {
+		t.Errorf("amount = %s, want a multiple of 0.0001", first.Amount)
+	}
+
+	again, err := s.CreatePremiumInvoice(1, "month", "TON", price, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID {
+		t.Errorf("second invoice of the same user = %d, want reused %d", again.ID, first.ID)
+	}
+
+	// Every reserved amount belongs to one invoice
+	seen := map[string]bool{first.Amount.String(): true}
+	for user := int64(2); user < 50; user++ {
+		inv, err := s.CreatePremiumInvoice(user, "month", "TON", price, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[inv.Amount.String()] {
+			t.Fatalf("amount %s given to two invoices", inv.Amount)
+		}
+		seen[inv.Amount.String()] = true
+	}
+
+	found, err := s.FindPremiumInvoiceByAmount("TON", first.Amount)
+	if err != nil || found.ID != first.ID {
+		t.Errorf("find by amount = %v, %v, want invoice %d", found, err, first.ID)
+	}
+	if _, err := s.FindPremiumInvoiceByAmount("USDT", first.Amount); err != ErrNotFound {
+		t.Errorf("find in another currency: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestAddPremiumInvoicePayment(t *testing.T) {
+	s := newTestStorage(t)
+
+	inv, err := s.CreatePremiumInvoice(1, "month", "USDT", tonapi.DecimalAmount(3, 6), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := tonapi.Amount{Units: new(big.Int).Quo(inv.Amount.Units, big.NewInt(2)), Decimals: 6}
+
+	tests := []struct {
+		name       string
+		amount     tonapi.Amount
+		wantStatus InvoiceStatus
+		wantFound  bool // still matched by amount afterwards
+	}{
+		{name: "underpaid", amount: half, wantStatus: InvoiceUnderpaid, wantFound: true},
+		{name: "rest paid", amount: inv.Amount.Sub(half), wantStatus: InvoicePaid, wantFound: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.AddPremiumInvoicePayment(inv.ID, tt.amount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+
+			_, err = s.FindPremiumInvoiceByAmount("USDT", inv.Amount)
+			if found := err == nil; found != tt.wantFound {
+				t.Errorf("found by amount = %v (%v), want %v", found, err, tt.wantFound)
+			}
+		})
+	}
+
+	paid, err := s.GetPremiumInvoiceByMemo(inv.Memo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paid.PaidAmount.Cmp(inv.Amount) != 0 || !paid.Remaining().IsZero() {
+		t.Errorf("paid %s of %s, remaining %s", paid.PaidAmount, paid.Amount, paid.Remaining())
+	}
+	if _, err := s.AddPremiumInvoicePayment(inv.ID, half); err != ErrNotFound {
+		t.Errorf("payment for a paid invoice: err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.AddPremiumInvoicePayment(inv.ID, tonapi.TONAmount(1)); err == nil {
+		t.Error("payment with other decimals accepted")
+	}
+}
+
+func TestApplyPremiumPayment(t *testing.T) {
+	s := newTestStorage(t)
+
+	inv, err := s.CreatePremiumInvoice(1, "month", "TON", tonapi.DecimalAmount(5, 9), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := tonapi.Amount{Units: new(big.Int).Quo(inv.Amount.Units, big.NewInt(2)), Decimals: 9}
+	payment := func(eventID string, amount tonapi.Amount) PremiumPayment {
+		return PremiumPayment{EventID: eventID, UserID: 1, Amount: amount, Currency: "TON", SenderAddress: "0:payer"}
+	}
+
+	tests := []struct {
+		name        string
+		payment     PremiumPayment
+		wantApplied bool
+		wantStatus  InvoiceStatus
+		wantPremium bool
+		wantErr     bool
+	}{
+		{name: "underpaid", payment: payment("ev1", half), wantApplied: true, wantStatus: InvoiceUnderpaid},
+		{name: "same event again", payment: payment("ev1", half)},
+		{name: "rest paid", payment: payment("ev2", inv.Amount.Sub(half)), wantApplied: true, wantStatus: InvoicePaid, wantPremium: true},
+		{name: "paid invoice fails", payment: payment("ev3", half), wantErr: true},
+		{name: "failed payment is not recorded", payment: payment("ev3", half), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applied, err := s.ApplyPremiumPayment(tt.payment, inv.ID, "month", 30*24*time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if (applied != nil) != tt.wantApplied {
+				t.Fatalf("applied = %+v, want applied %v", applied, tt.wantApplied)
+			}
+			if applied == nil {
+				return
+			}
+			if applied.Invoice.Status != tt.wantStatus {
+				t.Errorf("invoice status = %s, want %s", applied.Invoice.Status, tt.wantStatus)
+			}
+			if applied.ExpiresAt.IsZero() == tt.wantPremium {
+				t.Errorf("expires at = %v, want premium %v", applied.ExpiresAt, tt.wantPremium)
+			}
+			if s.IsPremium(1) != tt.wantPremium {
+				t.Errorf("premium = %v, want %v", s.IsPremium(1), tt.wantPremium)
+			}
+		})
+	}
+
+	// A payment without an invoice extends premium right away
+	applied, err := s.ApplyPremiumPayment(payment("ev4", half), 0, "month", 30*24*time.Hour)
+	if err != nil || applied == nil || applied.Invoice != nil || applied.ExpiresAt.IsZero() {
+		t.Errorf("payment without invoice = %+v, %v", applied, err)
+	}
+}
+
+func TestExpirePremiumInvoices(t *testing.T) {
+	tests := []struct {
+		name       string
+		deadline   time.Duration // relative to now
+		underpaid  bool
+		wantStatus InvoiceStatus
+		wantFound  bool
+	}{
+		{name: "open", deadline: time.Hour, wantStatus: InvoiceOpen, wantFound: true},
+		{name: "expired within grace", deadline: -time.Hour, wantStatus: InvoiceExpired, wantFound: true},
+		{name: "expired after grace", deadline: -invoiceReserveGrace - time.Hour, wantStatus: InvoiceExpired, wantFound: false},
+		{name: "underpaid within grace", deadline: -time.Hour, underpaid: true, wantStatus: InvoiceUnderpaid, wantFound: true},
+		{name: "underpaid after grace", deadline: -invoiceReserveGrace - time.Hour, underpaid: true, wantStatus: InvoiceUnderpaid, wantFound: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStorage(t)
+
+			inv, err := s.CreatePremiumInvoice(1, "month", "TON", tonapi.DecimalAmount(5, 9), time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.underpaid {
+				if _, err := s.AddPremiumInvoicePayment(inv.ID, tonapi.DecimalAmount(1, 9)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			setInvoiceDeadline(t, s, inv.ID, time.Now().Add(tt.deadline))
+
+			if _, err := s.ExpirePremiumInvoices(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := s.GetPremiumInvoiceByMemo(inv.Memo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+
+			_, err = s.FindPremiumInvoiceByAmount("TON", inv.Amount)
+			if found := err == nil; found != tt.wantFound {
+				t.Errorf("found by amount = %v (%v), want %v", found, err, tt.wantFound)
+			}
+
+			// A released amount can go to a new invoice, a reserved one can't
+			_, err = s.exec(
+				`INSERT INTO premium_invoices (user_id, plan, currency, amount, decimals, memo, created_at, expires_at)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				2, "month", "TON", inv.Amount.Units.String(), 9, "TTSAMEAMNT", 0, time.Now().Add(time.Hour).Unix(),
+			)
+			if reused := err == nil; reused == tt.wantFound {
+				t.Errorf("amount reused = %v (%v), want %v", reused, err, !tt.wantFound)
+			}
+
+			// Late payments by memo are still accepted
+			if _, err := s.AddPremiumInvoicePayment(inv.ID, inv.Remaining()); err != nil {
+				t.Errorf("late payment: %v", err)
+			}
+		})
+	}
+}
+
+func TestMarkPremiumPayment(t *testing.T) {
+	s := newTestStorage(t)
+
+	tests := []struct {
+		eventID string
+		amount  tonapi.Amount
+		wantNew bool
+		want    string
+	}{
+		{eventID: "ev1", amount: tonapi.DecimalAmount(5.0123, 9), wantNew: true, want: "5.0123"},
+		{eventID: "ev1", amount: tonapi.DecimalAmount(5.0123, 9), wantNew: false, want: "5.0123"},
+		{eventID: "stars:ch1", amount: tonapi.JettonAmount("250", 0), wantNew: true, want: "250"},
+		{eventID: "ev2", amount: tonapi.JettonAmount("123456789012345678901", 18), wantNew: true, want: "123.456789012345678901"},
+	}
+
+	for _, tt := range tests {
+		isNew, err := s.MarkPremiumPayment(tt.eventID, 1, tt.amount, "TON", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isNew != tt.wantNew {
+			t.Errorf("%s: new = %v, want %v", tt.eventID, isNew, tt.wantNew)
+		}
+
+		var stored string
+		if err := s.queryRow("SELECT amount FROM premium_payments WHERE event_id = ?", tt.eventID).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if stored != tt.want {
+			t.Errorf("%s: stored amount = %s, want %s", tt.eventID, stored, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package telegram
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Telegram reports flood limits as "Too Many Requests: retry after N"
+var retryAfterRegex = regexp.MustCompile(`retry after (\d+)`)
+
+// RetryAfter returns the delay requested by a Telegram 429 response
+func RetryAfter(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+
+	matches := retryAfterRegex.FindStringSubmatch(err.Error())
+	if len(matches) < 2 {
+		return 0, false
+	}
+
+	seconds, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// IsPermanent reports whether resending the same message can't succeed,
+// e.g. the user blocked the bot or the message is malformed
+func IsPermanent(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "Forbidden") || strings.Contains(msg, "Bad Request")
+}
@@ -1,16 +1,20 @@
 package telegram
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"html"
 	"log/slog"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/metrics"
 	"github.com/suspectuso/ton-tracker/internal/storage"
 	"github.com/suspectuso/ton-tracker/internal/tonapi"
 )
@@ -21,14 +25,18 @@ var addrRegex = regexp.MustCompile(`(0:[0-9A-Za-z:_-]{20,}|[UE]Q[0-9A-Za-z:_-]{2
 type Bot struct {
 	bot      *bot.Bot
 	cfg      *config.Config
-	storage  *storage.Storage
+	storage  storage.Store
 	tonAPI   *tonapi.Client
 	states   *StateManager
+	sender   *sendScheduler
+	jettons  jettonDecimals
+	premium  premiumCache
+	grant    PremiumActivator
 	log      *slog.Logger
 }
 
 // New creates a new telegram bot
-func New(cfg *config.Config, store *storage.Storage, tonAPI *tonapi.Client, log *slog.Logger) (*Bot, error) {
+func New(cfg *config.Config, store storage.Store, tonAPI *tonapi.Client, log *slog.Logger) (*Bot, error) {
 	b := &Bot{
 		cfg:     cfg,
 		storage: store,
@@ -48,6 +56,17 @@ func New(cfg *config.Config, store *storage.Storage, tonAPI *tonapi.Client, log
 	}
 
 	b.bot = tgBot
+	b.sender = newSendScheduler(cfg.TelegramGlobalRate, cfg.TelegramChatRate, func(ctx context.Context, params *bot.SendMessageParams) error {
+		start := time.Now()
+		_, err := tgBot.SendMessage(ctx, params)
+
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		metrics.TelegramSends.WithLabelValues(result).Observe(metrics.Since(start))
+		return err
+	})
 
 	// Register command handlers
 	tgBot.RegisterHandler(bot.HandlerTypeMessageText, "/start", bot.MatchTypeExact, b.startHandler)
@@ -57,9 +76,22 @@ func New(cfg *config.Config, store *storage.Storage, tonAPI *tonapi.Client, log
 	return b, nil
 }
 
-// Start starts the bot polling
+// Start starts the send scheduler and the bot polling.
+// It returns once both have stopped, including messages being sent.
 func (b *Bot) Start(ctx context.Context) {
+	senderDone := make(chan struct{})
+	go func() {
+		b.sender.run(ctx)
+		close(senderDone)
+	}()
+
 	b.bot.Start(ctx)
+	<-senderDone
+}
+
+// QueueDepth returns the number of messages waiting for a send slot
+func (b *Bot) QueueDepth() int {
+	return b.sender.depth()
 }
 
 // GetBot returns the underlying bot instance
@@ -92,8 +124,9 @@ func (b *Bot) startHandler(ctx context.Context, tgBot *bot.Bot, update *models.U
 	text := fmt.Sprintf(
 		"<a href='tg://user?id=%d'>%s</a>, добро пожаловать в <b>TON Tracker</b>! 🚀\n\n"+
 			"Я отслеживаю TON-кошельки и мгновенно уведомляю о:\n"+
-			"• Переводах TON\n"+
-			"• Свопах на DEX (STON.fi, DeDust)\n\n"+
+			"• Переводах TON и жетонов\n"+
+			"• Свопах на DEX (STON.fi, DeDust)\n"+
+			"• Покупках и переводах NFT\n\n"+
 			"Текущий лимит: <b>%d</b> кошельков%s\n\n"+
 			"Выбери действие 👇",
 		userID, userName, limit, vipNote,
@@ -114,8 +147,8 @@ func (b *Bot) meHandler(ctx context.Context, tgBot *bot.Bot, update *models.Upda
 	if b.cfg.VIPUserIDs[userID] {
 		flags = append(flags, "VIP")
 	}
-	if b.storage.IsPremium(userID) {
-		flags = append(flags, "Premium")
+	if p, err := b.storage.GetPremium(userID); err == nil && p.Active() {
+		flags = append(flags, "Premium до "+p.ExpiresAt.Format(premiumDateLayout))
 	}
 	if len(flags) == 0 {
 		flags = append(flags, "обычный")
@@ -134,6 +167,16 @@ func (b *Bot) meHandler(ctx context.Context, tgBot *bot.Bot, update *models.Upda
 }
 
 func (b *Bot) defaultHandler(ctx context.Context, tgBot *bot.Bot, update *models.Update) {
+	// Telegram Stars checkout
+	if update.PreCheckoutQuery != nil {
+		b.handlePreCheckout(ctx, update.PreCheckoutQuery)
+		return
+	}
+	if update.Message != nil && update.Message.SuccessfulPayment != nil {
+		b.handleSuccessfulPayment(ctx, update.Message)
+		return
+	}
+
 	if update.Message == nil || update.Message.Text == "" {
 		return
 	}
@@ -277,12 +320,19 @@ func (b *Bot) callbackHandler(ctx context.Context, tgBot *bot.Bot, update *model
 		b.handleSettings(ctx, cb, data)
 	case strings.HasPrefix(data, "cfg_min:"):
 		b.handleSetMinAmount(ctx, cb, data)
+	case strings.HasPrefix(data, "cfg_cat:"):
+		b.handleToggleCategory(ctx, cb, data)
 	case strings.HasPrefix(data, "cfg_reset:"):
 		b.handleResetFilters(ctx, cb, data)
 	case data == "premium":
 		b.showPremium(ctx, cb)
 	case data == "pay_wallet":
-		b.handlePayWallet(ctx, cb)
+		// Buttons sent before plans existed
+		b.handlePayWallet(ctx, cb, "pay:month")
+	case strings.HasPrefix(data, "pay:"):
+		b.handlePayWallet(ctx, cb, data)
+	case strings.HasPrefix(data, "stars:"):
+		b.handlePayStars(ctx, cb, data)
 	case data == "check_payment":
 		b.handleCheckPayment(ctx, cb)
 	default:
@@ -309,8 +359,9 @@ func (b *Bot) showMainMenu(ctx context.Context, cb *models.CallbackQuery) {
 	text := fmt.Sprintf(
 		"<a href='tg://user?id=%d'>%s</a>, добро пожаловать в <b>TON Tracker</b>! 🚀\n\n"+
 			"Я отслеживаю TON-кошельки и мгновенно уведомляю о:\n"+
-			"• Переводах TON\n"+
-			"• Свопах на DEX (STON.fi, DeDust)\n\n"+
+			"• Переводах TON и жетонов\n"+
+			"• Свопах на DEX (STON.fi, DeDust)\n"+
+			"• Покупках и переводах NFT\n\n"+
 			"Текущий лимит: <b>%d</b> кошельков%s\n\n"+
 			"Выбери действие 👇",
 		userID, userName, limit, vipNote,
@@ -340,10 +391,19 @@ func (b *Bot) showWalletList(ctx context.Context, cb *models.CallbackQuery) {
 
 	var lines []string
 	lines = append(lines, "📋 <b>Твои кошельки:</b>\n")
+	paused := 0
 	for _, w := range wallets {
-		lines = append(lines, fmt.Sprintf("• <b>%s</b> — %s", w.Name, w.AddressDisplay))
+		mark := "•"
+		if w.Paused {
+			mark = "⏸"
+			paused++
+		}
+		lines = append(lines, fmt.Sprintf("%s <b>%s</b> — %s", mark, html.EscapeString(w.Name), w.AddressDisplay))
 	}
 	lines = append(lines, fmt.Sprintf("\nЛимит: <b>%d</b> кошельков", limit))
+	if paused > 0 {
+		lines = append(lines, "⏸ — приостановлены после окончания Premium, снова заработают после продления")
+	}
 
 	b.editMessage(ctx, cb.Message, strings.Join(lines, "\n"), WalletsKeyboard(wallets))
 }
@@ -378,8 +438,16 @@ func (b *Bot) handleSettings(ctx context.Context, cb *models.CallbackQuery, data
 		minLine = fmt.Sprintf("Минимальная сумма: <b>%.2f TON</b>", *wallet.MinAmountTON)
 	}
 
-	text := fmt.Sprintf("⚙️ <b>Настройки: %s</b>\n\n%s", wallet.Name, minLine)
-	b.editMessage(ctx, cb.Message, text, WalletSettingsKeyboard(walletID))
+	if wallet.Paused {
+		minLine = "⏸ <b>Приостановлен</b> — превышен лимит кошельков\n" + minLine
+	}
+
+	text := fmt.Sprintf(
+		"⚙️ <b>Настройки: %s</b>\n\n%s\n\n"+
+			"Типы уведомлений — нажми, чтобы включить или выключить:",
+		html.EscapeString(wallet.Name), minLine,
+	)
+	b.editMessage(ctx, cb.Message, text, WalletSettingsKeyboard(wallet))
 }
 
 func (b *Bot) handleSetMinAmount(ctx context.Context, cb *models.CallbackQuery, data string) {
@@ -395,6 +463,24 @@ func (b *Bot) handleSetMinAmount(ctx context.Context, cb *models.CallbackQuery,
 	)
 }
 
+func (b *Bot) handleToggleCategory(ctx context.Context, cb *models.CallbackQuery, data string) {
+	// cfg_cat:<wallet_id>:<category>
+	parts := strings.Split(strings.TrimPrefix(data, "cfg_cat:"), ":")
+	if len(parts) != 2 {
+		return
+	}
+	walletID, _ := strconv.ParseInt(parts[0], 10, 64)
+	category, _ := strconv.Atoi(parts[1])
+
+	err := b.storage.ToggleWalletNotify(cb.From.ID, walletID, storage.NotifyCategory(category))
+	if err != nil {
+		b.log.Error("toggle notify category", "error", err)
+	}
+
+	// Refresh settings view
+	b.handleSettings(ctx, cb, fmt.Sprintf("cfg:%d", walletID))
+}
+
 func (b *Bot) handleResetFilters(ctx context.Context, cb *models.CallbackQuery, data string) {
 	walletID, _ := strconv.ParseInt(strings.TrimPrefix(data, "cfg_reset:"), 10, 64)
 
@@ -408,58 +494,202 @@ func (b *Bot) handleResetFilters(ctx context.Context, cb *models.CallbackQuery,
 }
 
 func (b *Bot) showPremium(ctx context.Context, cb *models.CallbackQuery) {
+	status := ""
+	if p, err := b.storage.GetPremium(cb.From.ID); err == nil {
+		if p.Active() {
+			status = fmt.Sprintf("✅ Premium активен до <b>%s</b>, продление добавится к оставшемуся сроку\n\n",
+				p.ExpiresAt.Format(premiumDateLayout))
+		} else {
+			status = fmt.Sprintf("⌛ Premium закончился <b>%s</b>\n\n", p.ExpiresAt.Format(premiumDateLayout))
+		}
+	}
+
+	var plans []string
+	for _, plan := range b.cfg.PremiumPlans {
+		prices := []string{formatTON(plan.PriceTON) + " TON"}
+		for _, j := range b.cfg.PremiumJettons {
+			if price, ok := j.Prices[plan.ID]; ok {
+				prices = append(prices, formatTON(price)+" "+j.Symbol)
+			}
+		}
+		if plan.PriceStars > 0 {
+			prices = append(prices, fmt.Sprintf("%d ⭐", plan.PriceStars))
+		}
+		plans = append(plans, fmt.Sprintf("• %s — <b>%s</b>", plan.Title, strings.Join(prices, "</b> / <b>")))
+	}
+
 	text := fmt.Sprintf(
-		"⭐ <b>Premium TON Tracker</b>\n\n"+
+		"⭐ <b>Premium TON Tracker</b>\n\n%s"+
 			"• Увеличенный лимит до <b>%d</b> кошельков\n"+
 			"• Приоритет в обработке\n\n"+
-			"💎 Стоимость: <b>%.0f TON</b>",
-		b.cfg.PremiumMaxWalletsPerUser, b.cfg.PremiumPriceTON,
+			"💎 Тарифы:\n%s",
+		status, b.cfg.PremiumMaxWalletsPerUser, strings.Join(plans, "\n"),
 	)
 
-	b.editMessage(ctx, cb.Message, text, PremiumKeyboard())
+	b.editMessage(ctx, cb.Message, text, PremiumKeyboard(b.cfg.PremiumPlans, b.cfg.PremiumJettons))
 }
 
-func (b *Bot) handlePayWallet(ctx context.Context, cb *models.CallbackQuery) {
+func (b *Bot) handlePayWallet(ctx context.Context, cb *models.CallbackQuery, data string) {
 	userID := cb.From.ID
 
-	// Generate unique amount
-	uniqueAmount := storage.GenerateUniqueAmount(userID, b.cfg.PremiumPriceTON)
-	b.storage.RegisterPendingPremium(userID, uniqueAmount)
+	// pay:<plan> for TON, pay:<plan>:<symbol> for a jetton
+	planID, symbol, _ := strings.Cut(strings.TrimPrefix(data, "pay:"), ":")
+	plan := b.cfg.PremiumPlanByID(planID)
+	if plan == nil {
+		b.showPremium(ctx, cb)
+		return
+	}
+
+	req := paymentRequest{To: b.cfg.ServiceWalletAddr}
+	price, currency := plan.PriceTON, "TON"
+	if symbol != "" {
+		jetton := b.cfg.PremiumJettonBySymbol(symbol)
+		if jetton == nil || jetton.Prices[plan.ID] == 0 {
+			b.showPremium(ctx, cb)
+			return
+		}
+		price, currency = jetton.Prices[plan.ID], jetton.Symbol
+		req.Jetton = jetton.Master
+	}
+
+	// Invoice amounts are exact units, so jetton decimals are needed up front
+	decimals := 9
+	if req.Jetton != "" {
+		var err error
+		decimals, err = b.jettons.get(ctx, b.tonAPI, req.Jetton)
+		if err != nil {
+			b.log.Error("get jetton decimals", "error", err, "jetton", req.Jetton)
+			b.editMessage(ctx, cb.Message, "❌ Не удалось создать счёт, попробуй позже.", BackKeyboard())
+			return
+		}
+	}
+
+	// The memo in the comment identifies the payment, the unique amount is a fallback
+	invoice, err := b.storage.CreatePremiumInvoice(userID, plan.ID, currency,
+		tonapi.DecimalAmount(price, decimals), b.cfg.PremiumInvoiceTTL)
+	if err != nil {
+		b.log.Error("create premium invoice", "error", err, "user_id", userID)
+		b.editMessage(ctx, cb.Message, "❌ Не удалось создать счёт, попробуй позже.", BackKeyboard())
+		return
+	}
+	req.Amount = invoice.Amount
+	req.Comment = invoice.Memo
 
 	text := fmt.Sprintf(
-		"💼 <b>Оплата Premium</b>\n\n"+
-			"Переведи <b>%.4f TON</b> на кошелёк:\n\n"+
+		"💼 <b>Оплата Premium: %s</b>\n\n"+
+			"Переведи <b>%s %s</b> на кошелёк:\n\n"+
 			"<code>%s</code>\n\n"+
-			"⚠️ <b>Важно:</b> переведи точно указанную сумму!\n"+
-			"Это позволит определить твой платёж без комментария.\n\n"+
-			"После оплаты нажми «Проверить оплату» 👇",
-		uniqueAmount, b.cfg.ServiceWalletAddr,
+			"с комментарием <code>%s</code>\n\n"+
+			"⚠️ <b>Важно:</b> без комментария переведи точно указанную сумму в течение %s — "+
+			"так платёж определится автоматически.\n\n",
+		plan.Title, invoice.Amount.String(), currency, b.cfg.ServiceWalletAddr, invoice.Memo,
+		formatDuration(time.Until(invoice.ExpiresAt)),
 	)
 
-	b.editMessage(ctx, cb.Message, text, CheckPaymentKeyboard())
+	text += "Проще всего — кнопкой ниже или по QR-коду: сумма и комментарий подставятся сами.\n" +
+		"После оплаты нажми «Проверить оплату» 👇"
+	b.editMessage(ctx, cb.Message, text, PayKeyboard(req.TonkeeperLink()))
+
+	if cb.Message.Message != nil {
+		b.sendPaymentQR(ctx, cb.Message.Message.Chat.ID, req)
+	}
+}
+
+// sendPaymentQR sends the payment deep link as a QR code to scan with any TON wallet
+func (b *Bot) sendPaymentQR(ctx context.Context, chatID int64, req paymentRequest) {
+	png, err := req.QRCode()
+	if err != nil {
+		b.log.Error("render payment qr", "error", err)
+		return
+	}
+
+	_, err = b.bot.SendPhoto(ctx, &bot.SendPhotoParams{
+		ChatID:  chatID,
+		Photo:   &models.InputFileUpload{Filename: "payment.png", Data: bytes.NewReader(png)},
+		Caption: "📷 Отсканируй QR-код в TON-кошельке",
+	})
+	if err != nil {
+		b.log.Error("send payment qr", "error", err)
+	}
 }
 
 func (b *Bot) handleCheckPayment(ctx context.Context, cb *models.CallbackQuery) {
 	userID := cb.From.ID
 
-	if b.storage.IsPremium(userID) {
-		text := fmt.Sprintf(
-			"✅ <b>Premium активен!</b>\n\n"+
+	invoice, err := b.storage.LatestPremiumInvoice(userID)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			b.log.Error("get premium invoice", "error", err, "user_id", userID)
+		}
+		b.showPremium(ctx, cb)
+		return
+	}
+
+	var text string
+	switch invoice.Status {
+	case storage.InvoicePaid:
+		p, err := b.storage.GetPremium(userID)
+		if err != nil {
+			b.log.Error("get premium", "error", err, "user_id", userID)
+			b.showPremium(ctx, cb)
+			return
+		}
+		text = fmt.Sprintf(
+			"✅ <b>Premium активен до %s</b>\n\n"+
 				"Твой лимит: <b>%d</b> кошельков",
-			b.cfg.PremiumMaxWalletsPerUser,
+			p.ExpiresAt.Format(premiumDateLayout), b.getMaxWallets(userID),
 		)
 		b.editMessage(ctx, cb.Message, text, StartMenuKeyboard())
 		return
-	}
 
-	text := "🔍 <b>Проверяем платёж...</b>\n\n" +
-		"Если ты только что отправил средства, подожди 10-30 секунд и нажми кнопку снова."
+	case storage.InvoiceUnderpaid:
+		text = fmt.Sprintf(
+			"💸 <b>Платёж получен не полностью</b>\n\n"+
+				"Получено <b>%s %s</b> из <b>%s %s</b>.\n"+
+				"Доплати <b>%s %s</b> на тот же кошелёк с комментарием <code>%s</code>.",
+			invoice.PaidAmount.String(), invoice.Currency,
+			invoice.Amount.String(), invoice.Currency,
+			invoice.Remaining().String(), invoice.Currency, invoice.Memo,
+		)
+
+	case storage.InvoiceExpired:
+		text = fmt.Sprintf(
+			"⌛ <b>Счёт истёк</b>\n\n"+
+				"Платёж на <b>%s %s</b> не найден. Если ты уже отправил средства с комментарием "+
+				"<code>%s</code>, они будут зачтены. Иначе выбери тариф заново.",
+			invoice.Amount.String(), invoice.Currency, invoice.Memo,
+		)
+
+	default:
+		text = fmt.Sprintf(
+			"🔍 <b>Платёж пока не найден</b>\n\n"+
+				"Ждём <b>%s %s</b> с комментарием <code>%s</code>, счёт действует ещё %s.\n"+
+				"Если ты только что отправил средства, подожди 10-30 секунд и нажми кнопку снова.",
+			invoice.Amount.String(), invoice.Currency, invoice.Memo,
+			formatDuration(time.Until(invoice.ExpiresAt)),
+		)
+	}
 
 	b.editMessage(ctx, cb.Message, text, CheckPaymentKeyboard())
 }
 
 // --- Helpers ---
 
+const premiumDateLayout = "02.01.2006"
+
+// formatTON formats a price without trailing zeros
+func formatTON(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
+
+// formatDuration formats a short duration as minutes or hours
+func formatDuration(d time.Duration) string {
+	if d < time.Hour {
+		return fmt.Sprintf("%d мин", max(1, int(d.Minutes())))
+	}
+	return fmt.Sprintf("%d ч", int(d.Hours()))
+}
+
 func (b *Bot) getMaxWallets(userID int64) int {
 	if b.cfg.VIPUserIDs[userID] {
 		return b.cfg.VIPMaxWalletsPerUser
@@ -480,8 +710,7 @@ func (b *Bot) sendMessage(ctx context.Context, chatID int64, text string, keyboa
 		params.ReplyMarkup = keyboard
 	}
 
-	_, err := b.bot.SendMessage(ctx, params)
-	if err != nil {
+	if err := b.sender.submit(ctx, priorityInteractive, chatID, params); err != nil {
 		b.log.Error("send message", "error", err)
 	}
 }
@@ -507,7 +736,8 @@ func (b *Bot) editMessage(ctx context.Context, msg models.MaybeInaccessibleMessa
 	}
 }
 
-// SendNotification sends a notification message to a user
+// SendNotification sends a notification message to a user.
+// It waits for a free slot under Telegram rate limits, premium users go first.
 func (b *Bot) SendNotification(ctx context.Context, userID int64, text string, keyboard *models.InlineKeyboardMarkup) error {
 	disablePreview := true
 	params := &bot.SendMessageParams{
@@ -522,8 +752,12 @@ func (b *Bot) SendNotification(ctx context.Context, userID int64, text string, k
 		params.ReplyMarkup = keyboard
 	}
 
-	_, err := b.bot.SendMessage(ctx, params)
-	return err
+	prio := priorityNormal
+	if b.cfg.VIPUserIDs[userID] || b.premium.isPremium(userID, b.storage.IsPremium) {
+		prio = priorityPremium
+	}
+
+	return b.sender.submit(ctx, prio, userID, params)
 }
 
 func extractAddress(text string) string {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/go-telegram/bot/models"
+	"github.com/suspectuso/ton-tracker/internal/config"
 	"github.com/suspectuso/ton-tracker/internal/storage"
 )
 
@@ -42,21 +43,59 @@ func WalletsKeyboard(wallets []storage.Wallet) *models.InlineKeyboardMarkup {
 	return &models.InlineKeyboardMarkup{InlineKeyboard: rows}
 }
 
+// notifyCategories lists toggleable notification categories in display order
+var notifyCategories = []struct {
+	Category storage.NotifyCategory
+	Label    string
+}{
+	{storage.NotifyTonIn, "Входящие TON"},
+	{storage.NotifyTonOut, "Исходящие TON"},
+	{storage.NotifySwapBuy, "Покупки (DEX)"},
+	{storage.NotifySwapSell, "Продажи (DEX)"},
+	{storage.NotifyJettons, "Жетоны"},
+	{storage.NotifyNFT, "NFT"},
+	{storage.NotifyStaking, "Стейкинг"},
+	{storage.NotifyContractCalls, "Вызовы контрактов"},
+}
+
 // WalletSettingsKeyboard returns settings keyboard for a wallet
-func WalletSettingsKeyboard(walletID int64) *models.InlineKeyboardMarkup {
-	return &models.InlineKeyboardMarkup{
-		InlineKeyboard: [][]models.InlineKeyboardButton{
-			{
-				{Text: "⬇️ Минимальная сумма", CallbackData: fmt.Sprintf("cfg_min:%d", walletID)},
-			},
-			{
-				{Text: "♻️ Сбросить фильтры", CallbackData: fmt.Sprintf("cfg_reset:%d", walletID)},
-			},
-			{
-				{Text: "⬅️ Назад", CallbackData: "list"},
-			},
+func WalletSettingsKeyboard(wallet *storage.Wallet) *models.InlineKeyboardMarkup {
+	rows := [][]models.InlineKeyboardButton{
+		{
+			{Text: "⬇️ Минимальная сумма", CallbackData: fmt.Sprintf("cfg_min:%d", wallet.ID)},
 		},
 	}
+
+	// Category toggles, two per row
+	var row []models.InlineKeyboardButton
+	for _, c := range notifyCategories {
+		mark := "❌"
+		if wallet.Notifies(c.Category) {
+			mark = "✅"
+		}
+		row = append(row, models.InlineKeyboardButton{
+			Text:         mark + " " + c.Label,
+			CallbackData: fmt.Sprintf("cfg_cat:%d:%d", wallet.ID, c.Category),
+		})
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	rows = append(rows,
+		[]models.InlineKeyboardButton{
+			{Text: "♻️ Сбросить фильтры", CallbackData: fmt.Sprintf("cfg_reset:%d", wallet.ID)},
+		},
+		[]models.InlineKeyboardButton{
+			{Text: "⬅️ Назад", CallbackData: "list"},
+		},
+	)
+
+	return &models.InlineKeyboardMarkup{InlineKeyboard: rows}
 }
 
 // BackKeyboard returns a simple back button
@@ -70,15 +109,50 @@ func BackKeyboard() *models.InlineKeyboardMarkup {
 	}
 }
 
-// PremiumKeyboard returns premium payment options keyboard
-func PremiumKeyboard() *models.InlineKeyboardMarkup {
+// PremiumKeyboard returns premium payment options keyboard,
+// a row per plan with TON, accepted jetton and Telegram Stars prices
+func PremiumKeyboard(plans []config.PremiumPlan, jettons []config.PremiumJetton) *models.InlineKeyboardMarkup {
+	var rows [][]models.InlineKeyboardButton
+	for _, plan := range plans {
+		row := []models.InlineKeyboardButton{
+			{Text: fmt.Sprintf("💼 %s — %s TON", plan.Title, formatTON(plan.PriceTON)), CallbackData: "pay:" + plan.ID},
+		}
+		for _, j := range jettons {
+			if price, ok := j.Prices[plan.ID]; ok {
+				row = append(row, models.InlineKeyboardButton{
+					Text:         fmt.Sprintf("%s %s", formatTON(price), j.Symbol),
+					CallbackData: "pay:" + plan.ID + ":" + j.Symbol,
+				})
+			}
+		}
+		if plan.PriceStars > 0 {
+			row = append(row, models.InlineKeyboardButton{
+				Text:         fmt.Sprintf("%d ⭐", plan.PriceStars),
+				CallbackData: "stars:" + plan.ID,
+			})
+		}
+		rows = append(rows, row)
+	}
+
+	rows = append(rows, []models.InlineKeyboardButton{
+		{Text: "⬅️ Назад", CallbackData: "back"},
+	})
+
+	return &models.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// PayKeyboard returns keyboard with a one-tap wallet link and payment check
+func PayKeyboard(walletURL string) *models.InlineKeyboardMarkup {
 	return &models.InlineKeyboardMarkup{
 		InlineKeyboard: [][]models.InlineKeyboardButton{
 			{
-				{Text: "💼 Оплатить", CallbackData: "pay_wallet"},
+				{Text: "💎 Оплатить в Tonkeeper", URL: walletURL},
 			},
 			{
-				{Text: "⬅️ Назад", CallbackData: "back"},
+				{Text: "🔄 Проверить оплату", CallbackData: "check_payment"},
+			},
+			{
+				{Text: "⬅️ Назад", CallbackData: "premium"},
 			},
 		},
 	}
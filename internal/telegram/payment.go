@@ -0,0 +1,87 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+// paymentQRSize is the side of the payment QR code image in pixels
+const paymentQRSize = 512
+
+// paymentRequest describes a transfer the user is asked to make
+type paymentRequest struct {
+	To      string        // recipient address
+	Amount  tonapi.Amount // nanoTON or jetton units
+	Jetton  string        // jetton master, empty for TON
+	Comment string
+}
+
+// units returns the amount in the smallest units, as wallets expect it in links
+func (p paymentRequest) units() string {
+	if p.Amount.Units == nil {
+		return "0"
+	}
+	return p.Amount.Units.String()
+}
+
+func (p paymentRequest) query() string {
+	q := url.Values{}
+	q.Set("amount", p.units())
+	if p.Jetton != "" {
+		q.Set("jetton", p.Jetton)
+	}
+	if p.Comment != "" {
+		q.Set("text", p.Comment)
+	}
+	return q.Encode()
+}
+
+// TonLink returns a ton://transfer deep link understood by TON wallets.
+// Telegram doesn't accept ton:// in buttons, so it goes into the QR code.
+func (p paymentRequest) TonLink() string {
+	return fmt.Sprintf("ton://transfer/%s?%s", p.To, p.query())
+}
+
+// TonkeeperLink returns a Tonkeeper universal link, usable as a URL button
+func (p paymentRequest) TonkeeperLink() string {
+	return fmt.Sprintf("https://app.tonkeeper.com/transfer/%s?%s", p.To, p.query())
+}
+
+// QRCode renders the ton:// link as a PNG image
+func (p paymentRequest) QRCode() ([]byte, error) {
+	return qrcode.Encode(p.TonLink(), qrcode.Medium, paymentQRSize)
+}
+
+// jettonDecimals caches jetton decimals by master address
+type jettonDecimals struct {
+	mu    sync.Mutex
+	known map[string]int
+}
+
+func (j *jettonDecimals) get(ctx context.Context, tonAPI *tonapi.Client, master string) (int, error) {
+	j.mu.Lock()
+	decimals, ok := j.known[master]
+	j.mu.Unlock()
+	if ok {
+		return decimals, nil
+	}
+
+	info, err := tonAPI.GetJettonInfo(ctx, master)
+	if err != nil {
+		return 0, err
+	}
+
+	j.mu.Lock()
+	if j.known == nil {
+		j.known = make(map[string]int)
+	}
+	j.known[master] = info.Decimals
+	j.mu.Unlock()
+
+	return info.Decimals, nil
+}
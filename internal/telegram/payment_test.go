@@ -0,0 +1,66 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+func TestPaymentRequestUnits(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount tonapi.Amount
+		want   string
+	}{
+		{name: "ton with suffix", amount: tonapi.DecimalAmount(5.0123, 9), want: "5012300000"},
+		{name: "usdt with suffix", amount: tonapi.DecimalAmount(3.0999, 6), want: "3099900"},
+		{name: "exact nano", amount: tonapi.TONAmount(1_000_000_001), want: "1000000001"},
+		{name: "large jetton amount", amount: tonapi.JettonAmount("123456789012345678901234", 18), want: "123456789012345678901234"},
+		{name: "no decimals", amount: tonapi.DecimalAmount(150, 0), want: "150"},
+		{name: "empty", want: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := paymentRequest{Amount: tt.amount}
+			if got := req.units(); got != tt.want {
+				t.Errorf("units() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaymentRequestLinks(t *testing.T) {
+	const to = "UQservice"
+
+	tests := []struct {
+		name       string
+		req        paymentRequest
+		wantTon    string
+		wantKeeper string
+	}{
+		{
+			name:       "ton",
+			req:        paymentRequest{To: to, Amount: tonapi.DecimalAmount(5.0123, 9), Comment: "TT-AB12"},
+			wantTon:    "ton://transfer/UQservice?amount=5012300000&text=TT-AB12",
+			wantKeeper: "https://app.tonkeeper.com/transfer/UQservice?amount=5012300000&text=TT-AB12",
+		},
+		{
+			name:       "jetton",
+			req:        paymentRequest{To: to, Amount: tonapi.DecimalAmount(3.0999, 6), Jetton: "EQusdt", Comment: "TT 1"},
+			wantTon:    "ton://transfer/UQservice?amount=3099900&jetton=EQusdt&text=TT+1",
+			wantKeeper: "https://app.tonkeeper.com/transfer/UQservice?amount=3099900&jetton=EQusdt&text=TT+1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.TonLink(); got != tt.wantTon {
+				t.Errorf("TonLink() = %s, want %s", got, tt.wantTon)
+			}
+			if got := tt.req.TonkeeperLink(); got != tt.wantKeeper {
+				t.Errorf("TonkeeperLink() = %s, want %s", got, tt.wantKeeper)
+			}
+		})
+	}
+}
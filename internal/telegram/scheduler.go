@@ -0,0 +1,310 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+var errSchedulerStopped = errors.New("send scheduler stopped")
+
+// sendPriority orders queued messages, lower values are sent first
+type sendPriority int
+
+const (
+	priorityInteractive sendPriority = iota // replies to user actions
+	priorityPremium
+	priorityNormal
+	numPriorities
+)
+
+const (
+	// Telegram's documented limits, used when the configured rates are invalid
+	defaultGlobalRate = 30
+	defaultChatRate   = 1
+
+	// chatBucketIdle is how long an unused per-chat bucket is kept
+	chatBucketIdle = time.Minute
+)
+
+type sendRequest struct {
+	ctx    context.Context
+	chatID int64
+	params *bot.SendMessageParams
+	done   chan error
+}
+
+// sendScheduler paces outgoing messages to stay within Telegram limits:
+// a global token bucket for the whole bot and one bucket per chat.
+// Within those limits, higher priority queues are drained first.
+type sendScheduler struct {
+	send func(ctx context.Context, params *bot.SendMessageParams) error
+
+	mu        sync.Mutex
+	queues    [numPriorities][]*sendRequest
+	global    *tokenBucket
+	chats     map[int64]*tokenBucket
+	chatRate  float64
+	lastPrune time.Time
+
+	wake     chan struct{}
+	inflight sync.WaitGroup // sends started by run
+}
+
+func newSendScheduler(globalRate, chatRate float64, send func(ctx context.Context, params *bot.SendMessageParams) error) *sendScheduler {
+	if globalRate <= 0 {
+		globalRate = defaultGlobalRate
+	}
+	if chatRate <= 0 {
+		chatRate = defaultChatRate
+	}
+
+	return &sendScheduler{
+		send:      send,
+		global:    newTokenBucket(globalRate, 1),
+		chats:     make(map[int64]*tokenBucket),
+		chatRate:  chatRate,
+		lastPrune: time.Now(),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// submit queues a message and waits until it is sent
+func (s *sendScheduler) submit(ctx context.Context, prio sendPriority, chatID int64, params *bot.SendMessageParams) error {
+	req := &sendRequest{
+		ctx:    ctx,
+		chatID: chatID,
+		params: params,
+		done:   make(chan error, 1),
+	}
+
+	s.mu.Lock()
+	s.queues[prio] = append(s.queues[prio], req)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		// The dispatcher drops cancelled requests when it reaches them
+		return ctx.Err()
+	}
+}
+
+// depth returns the number of queued messages
+func (s *sendScheduler) depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, q := range s.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// run dispatches queued messages until ctx is cancelled.
+// It returns after in-flight sends have finished.
+func (s *sendScheduler) run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		req, wait := s.next(time.Now())
+		if req != nil {
+			s.inflight.Add(1)
+			go func() {
+				defer s.inflight.Done()
+				req.done <- s.send(req.ctx, req.params)
+			}()
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			s.stop()
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// next pops the first message that can be sent now.
+// Otherwise it returns how long to wait before trying again.
+func (s *sendScheduler) next(now time.Time) (*sendRequest, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastPrune) > chatBucketIdle {
+		s.pruneChats(now)
+	}
+
+	if wait := s.global.wait(now); wait > 0 {
+		return nil, wait
+	}
+
+	minWait := time.Hour
+	for prio := range s.queues {
+		queue := s.queues[prio]
+		for i := 0; i < len(queue); i++ {
+			req := queue[i]
+			if err := req.ctx.Err(); err != nil {
+				req.done <- err
+				queue = append(queue[:i], queue[i+1:]...)
+				i--
+				continue
+			}
+
+			chat := s.chatBucket(req.chatID)
+			if wait := chat.wait(now); wait > 0 {
+				if wait < minWait {
+					minWait = wait
+				}
+				continue
+			}
+
+			chat.take()
+			s.global.take()
+			s.queues[prio] = append(queue[:i], queue[i+1:]...)
+			return req, 0
+		}
+		s.queues[prio] = queue
+	}
+
+	return nil, minWait
+}
+
+func (s *sendScheduler) chatBucket(chatID int64) *tokenBucket {
+	tb, ok := s.chats[chatID]
+	if !ok {
+		tb = newTokenBucket(s.chatRate, 1)
+		s.chats[chatID] = tb
+	}
+	return tb
+}
+
+// pruneChats drops buckets of chats that have been idle long enough to be full again
+func (s *sendScheduler) pruneChats(now time.Time) {
+	for chatID, tb := range s.chats {
+		if now.Sub(tb.last) > chatBucketIdle {
+			delete(s.chats, chatID)
+		}
+	}
+	s.lastPrune = now
+}
+
+// stop fails all queued messages and waits for in-flight sends
+func (s *sendScheduler) stop() {
+	s.mu.Lock()
+	for prio, queue := range s.queues {
+		for _, req := range queue {
+			req.done <- errSchedulerStopped
+		}
+		s.queues[prio] = nil
+	}
+	s.mu.Unlock()
+
+	s.inflight.Wait()
+}
+
+// tokenBucket allows rate events per second with bursts of up to burst events
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait refills the bucket and returns how long until a token is available
+func (tb *tokenBucket) wait(now time.Time) time.Duration {
+	if now.After(tb.last) {
+		tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+		tb.last = now
+	}
+
+	if tb.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+}
+
+// take consumes a token, call it after wait returned zero
+func (tb *tokenBucket) take() {
+	tb.tokens--
+}
+
+const (
+	// premiumCacheTTL is how long a premium status is reused to pick a send priority
+	premiumCacheTTL = time.Minute
+
+	// premiumCachePrune is the cache size above which stale entries are dropped
+	premiumCachePrune = 10_000
+)
+
+// premiumCache remembers recent premium lookups, so that a burst of
+// notifications doesn't query the database for every message
+type premiumCache struct {
+	mu    sync.Mutex
+	known map[int64]premiumStatus
+}
+
+type premiumStatus struct {
+	premium   bool
+	checkedAt time.Time
+}
+
+// isPremium returns the cached status of a user, calling check when it is missing or stale
+func (c *premiumCache) isPremium(userID int64, check func(userID int64) bool) bool {
+	c.mu.Lock()
+	st, ok := c.known[userID]
+	c.mu.Unlock()
+	if ok && time.Since(st.checkedAt) < premiumCacheTTL {
+		return st.premium
+	}
+
+	st = premiumStatus{premium: check(userID), checkedAt: time.Now()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.known == nil {
+		c.known = make(map[int64]premiumStatus)
+	}
+	if len(c.known) >= premiumCachePrune {
+		for id, old := range c.known {
+			if time.Since(old.checkedAt) >= premiumCacheTTL {
+				delete(c.known, id)
+			}
+		}
+	}
+	c.known[userID] = st
+	return st.premium
+}
@@ -0,0 +1,155 @@
+package telegram
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+func TestTokenBucketWait(t *testing.T) {
+	start := time.Now()
+
+	tests := []struct {
+		name  string
+		take  int
+		after time.Duration
+		want  time.Duration
+	}{
+		{name: "full bucket", want: 0},
+		{name: "empty bucket", take: 1, want: 500 * time.Millisecond},
+		{name: "partly refilled", take: 1, after: 200 * time.Millisecond, want: 300 * time.Millisecond},
+		{name: "refilled", take: 1, after: time.Second, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := newTokenBucket(2, 1)
+			tb.last = start
+			for i := 0; i < tt.take; i++ {
+				tb.wait(start)
+				tb.take()
+			}
+			if got := tb.wait(start.Add(tt.after)); got != tt.want {
+				t.Errorf("wait = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendSchedulerPriority(t *testing.T) {
+	s := newSendScheduler(1000, 1, nil)
+	ctx := context.Background()
+
+	queue := func(prio sendPriority, chatID int64) {
+		s.queues[prio] = append(s.queues[prio], &sendRequest{ctx: ctx, chatID: chatID, done: make(chan error, 1)})
+	}
+	queue(priorityNormal, 1)
+	queue(priorityInteractive, 2)
+	queue(priorityInteractive, 2)
+	queue(priorityPremium, 3)
+
+	// The second interactive message waits for its chat bucket, so others go first
+	var order []int64
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		req, _ := s.next(now)
+		if req == nil {
+			t.Fatalf("no request at step %d", i)
+		}
+		order = append(order, req.chatID)
+		now = now.Add(10 * time.Millisecond)
+	}
+
+	want := []int64{2, 3, 1}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("send order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestSendSchedulerStopWaitsForSends(t *testing.T) {
+	release := make(chan struct{})
+	var finished atomic.Bool
+
+	s := newSendScheduler(1000, 1000, func(ctx context.Context, params *bot.SendMessageParams) error {
+		<-release
+		finished.Store(true)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runDone := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(runDone)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.submit(context.Background(), priorityNormal, 1, &bot.SendMessageParams{})
+	}()
+
+	// Wait until the message is handed to send
+	for s.depth() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	select {
+	case <-runDone:
+		t.Fatal("run returned while a send was in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-runDone
+	if !finished.Load() {
+		t.Error("run returned before the send finished")
+	}
+	wg.Wait()
+}
+
+func TestPremiumCache(t *testing.T) {
+	var c premiumCache
+	premium := map[int64]bool{1: true}
+	lookups := 0
+	check := func(userID int64) bool {
+		lookups++
+		return premium[userID]
+	}
+
+	tests := []struct {
+		name        string
+		userID      int64
+		stale       bool // age the cached entry first
+		want        bool
+		wantLookups int
+	}{
+		{name: "first lookup", userID: 1, want: true, wantLookups: 1},
+		{name: "cached", userID: 1, want: true, wantLookups: 1},
+		{name: "other user", userID: 2, want: false, wantLookups: 2},
+		{name: "stale entry is checked again", userID: 1, stale: true, want: true, wantLookups: 3},
+	}
+
+	for _, tt := range tests {
+		if tt.stale {
+			st := c.known[tt.userID]
+			st.checkedAt = st.checkedAt.Add(-premiumCacheTTL)
+			c.known[tt.userID] = st
+		}
+		if got := c.isPremium(tt.userID, check); got != tt.want {
+			t.Errorf("%s: premium = %v, want %v", tt.name, got, tt.want)
+		}
+		if lookups != tt.wantLookups {
+			t.Errorf("%s: lookups = %d, want %d", tt.name, lookups, tt.wantLookups)
+		}
+	}
+}
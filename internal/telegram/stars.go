@@ -0,0 +1,212 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+const (
+	// starsCurrency is the Telegram Stars currency, invoices in it need no payment provider
+	starsCurrency = "XTR"
+
+	// starsPayloadPrefix marks invoice payloads of premium plans: premium:<plan>
+	starsPayloadPrefix = "premium:"
+
+	// starsEventPrefix keeps Stars charge IDs apart from TON event IDs in premium payments
+	starsEventPrefix = "stars:"
+
+	// telegramAPIURL is the Bot API endpoint for methods called without the bot library
+	telegramAPIURL = "https://api.telegram.org"
+)
+
+// refundClient sends refundStarPayment requests
+var refundClient = &http.Client{Timeout: 15 * time.Second}
+
+// PremiumActivator activates a paid premium plan and notifies the user,
+// implemented by notifier.PremiumChecker.Grant. An error means the plan wasn't activated.
+type PremiumActivator func(userID int64, plan *config.PremiumPlan, amount tonapi.Amount, currency, sender, eventID string) error
+
+// SetPremiumActivator sets how premium bought with Telegram Stars is activated
+func (b *Bot) SetPremiumActivator(grant PremiumActivator) {
+	b.grant = grant
+}
+
+// handlePayStars sends a Telegram Stars invoice for a plan
+func (b *Bot) handlePayStars(ctx context.Context, cb *models.CallbackQuery, data string) {
+	plan := b.cfg.PremiumPlanByID(strings.TrimPrefix(data, "stars:"))
+	if plan == nil || plan.PriceStars <= 0 || cb.Message.Message == nil {
+		b.showPremium(ctx, cb)
+		return
+	}
+
+	_, err := b.bot.SendInvoice(ctx, &bot.SendInvoiceParams{
+		ChatID:      cb.Message.Message.Chat.ID,
+		Title:       "Premium TON Tracker — " + plan.Title,
+		Description: fmt.Sprintf("Лимит до %d кошельков и приоритет в обработке на %s", b.cfg.PremiumMaxWalletsPerUser, plan.Title),
+		Payload:     starsPayloadPrefix + plan.ID,
+		Currency:    starsCurrency,
+		Prices: []models.LabeledPrice{
+			{Label: "Premium: " + plan.Title, Amount: plan.PriceStars},
+		},
+	})
+	if err != nil {
+		b.log.Error("send stars invoice", "error", err, "user_id", cb.From.ID)
+		b.editMessage(ctx, cb.Message, "❌ Не удалось создать счёт, попробуй позже.", BackKeyboard())
+	}
+}
+
+// handlePreCheckout confirms a Stars payment if the invoice still matches a plan and its price
+func (b *Bot) handlePreCheckout(ctx context.Context, q *models.PreCheckoutQuery) {
+	params := &bot.AnswerPreCheckoutQueryParams{
+		PreCheckoutQueryID: q.ID,
+		OK:                 true,
+	}
+
+	if _, reason := b.checkStarsPayment(q.InvoicePayload, q.Currency, q.TotalAmount); reason != "" {
+		params.OK = false
+		params.ErrorMessage = reason
+		b.log.Warn("stars pre-checkout rejected",
+			"payload", q.InvoicePayload,
+			"currency", q.Currency,
+			"amount", q.TotalAmount,
+		)
+	}
+
+	if _, err := b.bot.AnswerPreCheckoutQuery(ctx, params); err != nil {
+		b.log.Error("answer pre-checkout query", "error", err)
+	}
+}
+
+// handleSuccessfulPayment records a Stars payment, then activates the plan or
+// refunds the payment if it can't be activated
+func (b *Bot) handleSuccessfulPayment(ctx context.Context, msg *models.Message) {
+	payment := msg.SuccessfulPayment
+	userID := msg.From.ID
+	eventID := starsEventPrefix + payment.TelegramPaymentChargeID
+	amount := tonapi.Amount{Units: big.NewInt(int64(payment.TotalAmount))}
+
+	// Recorded first, so a charge is granted or refunded only once
+	isNew, err := b.storage.MarkPremiumPayment(eventID, userID, amount, payment.Currency, "")
+	if err != nil {
+		b.log.Error("mark premium payment", "error", err, "user_id", userID)
+		b.refundStars(ctx, msg.Chat.ID, userID, payment.TelegramPaymentChargeID)
+		return
+	}
+	if !isNew {
+		return
+	}
+
+	plan, reason := b.checkStarsPayment(payment.InvoicePayload, payment.Currency, payment.TotalAmount)
+	if reason != "" {
+		// Checked at pre-checkout, only a config change in between gets here
+		b.log.Error("stars payment can't be activated",
+			"user_id", userID,
+			"payload", payment.InvoicePayload,
+			"currency", payment.Currency,
+			"amount", payment.TotalAmount,
+			"charge_id", payment.TelegramPaymentChargeID,
+		)
+		b.refundStars(ctx, msg.Chat.ID, userID, payment.TelegramPaymentChargeID)
+		return
+	}
+
+	if err := b.grant(userID, plan, amount, starsCurrency, "", eventID); err != nil {
+		b.log.Error("activate stars premium", "error", err, "user_id", userID, "charge_id", payment.TelegramPaymentChargeID)
+		b.refundStars(ctx, msg.Chat.ID, userID, payment.TelegramPaymentChargeID)
+	}
+}
+
+// checkStarsPayment returns the plan a Stars payment buys, or the reason shown
+// to the user when the payment can't be accepted
+func (b *Bot) checkStarsPayment(payload, currency string, amount int) (*config.PremiumPlan, string) {
+	plan := b.starsPlan(payload)
+	switch {
+	case b.grant == nil:
+		return nil, "Оплата звёздами сейчас недоступна, попробуй позже."
+	case plan == nil:
+		return nil, "Этот тариф больше недоступен, открой ⭐ Premium заново."
+	case currency != starsCurrency || amount != plan.PriceStars:
+		return nil, "Цена изменилась, открой ⭐ Premium заново."
+	}
+	return plan, ""
+}
+
+// refundStars returns a Stars payment that wasn't activated and tells the user
+func (b *Bot) refundStars(ctx context.Context, chatID, userID int64, chargeID string) {
+	text := "↩️ Не удалось активировать Premium, звёзды возвращены. Открой ⭐ Premium и попробуй снова."
+	if err := b.refundStarPayment(ctx, userID, chargeID); err != nil {
+		b.log.Error("refund stars payment", "error", err, "user_id", userID, "charge_id", chargeID)
+		text = "❌ Не удалось активировать Premium и вернуть звёзды автоматически.\n" +
+			"Напиши администратору бота и укажи код платежа: <code>" + html.EscapeString(chargeID) + "</code>"
+	} else {
+		b.log.Info("stars payment refunded", "user_id", userID, "charge_id", chargeID)
+	}
+
+	b.sendMessage(ctx, chatID, text, nil)
+}
+
+// refundStarPayment calls the refundStarPayment Bot API method, which the bot
+// library version in use doesn't wrap
+func (b *Bot) refundStarPayment(ctx context.Context, userID int64, chargeID string) error {
+	form := url.Values{}
+	form.Set("user_id", strconv.FormatInt(userID, 10))
+	form.Set("telegram_payment_charge_id", chargeID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		telegramAPIURL+"/bot"+b.cfg.BotToken+"/refundStarPayment", strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.New("build refundStarPayment request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := refundClient.Do(req)
+	if err != nil {
+		// The request URL carries the bot token, keep it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = telegramAPIURL + "/bot<token>/refundStarPayment"
+		}
+		return fmt.Errorf("refundStarPayment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("refundStarPayment: decode response: %w", err)
+	}
+	if !result.OK {
+		return fmt.Errorf("refundStarPayment: %s", result.Description)
+	}
+	return nil
+}
+
+// starsPlan returns the plan of a Stars invoice payload, or nil
+func (b *Bot) starsPlan(payload string) *config.PremiumPlan {
+	planID, ok := strings.CutPrefix(payload, starsPayloadPrefix)
+	if !ok {
+		return nil
+	}
+
+	plan := b.cfg.PremiumPlanByID(planID)
+	if plan == nil || plan.PriceStars <= 0 {
+		return nil
+	}
+	return plan
+}
@@ -0,0 +1,81 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+func TestCheckStarsPayment(t *testing.T) {
+	cfg := &config.Config{
+		PremiumPlans: []config.PremiumPlan{
+			{ID: "month", PriceTON: 5, PriceStars: 250},
+			{ID: "year", PriceTON: 50},
+		},
+	}
+	grant := func(userID int64, plan *config.PremiumPlan, amount tonapi.Amount, currency, sender, eventID string) error { return nil }
+
+	tests := []struct {
+		name     string
+		payload  string
+		currency string
+		amount   int
+		noGrant  bool
+		wantPlan string // empty if rejected
+	}{
+		{name: "valid", payload: "premium:month", currency: "XTR", amount: 250, wantPlan: "month"},
+		{name: "unknown plan", payload: "premium:week", currency: "XTR", amount: 250},
+		{name: "plan not sold for stars", payload: "premium:year", currency: "XTR", amount: 250},
+		{name: "foreign payload", payload: "month", currency: "XTR", amount: 250},
+		{name: "price changed", payload: "premium:month", currency: "XTR", amount: 200},
+		{name: "other currency", payload: "premium:month", currency: "USD", amount: 250},
+		{name: "activation not configured", payload: "premium:month", currency: "XTR", amount: 250, noGrant: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Bot{cfg: cfg, grant: grant}
+			if tt.noGrant {
+				b.grant = nil
+			}
+
+			plan, reason := b.checkStarsPayment(tt.payload, tt.currency, tt.amount)
+			if tt.wantPlan == "" {
+				if plan != nil || reason == "" {
+					t.Errorf("accepted: plan %v, reason %q", plan, reason)
+				}
+				return
+			}
+			if plan == nil || plan.ID != tt.wantPlan || reason != "" {
+				t.Errorf("plan %v, reason %q, want plan %s", plan, reason, tt.wantPlan)
+			}
+		})
+	}
+}
+
+// failingTransport fails every request like an unreachable network
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("network is unreachable")
+}
+
+func TestRefundStarPaymentError(t *testing.T) {
+	saved := refundClient
+	refundClient = &http.Client{Transport: failingTransport{}}
+	t.Cleanup(func() { refundClient = saved })
+
+	b := &Bot{cfg: &config.Config{BotToken: "123:secret-token"}}
+	err := b.refundStarPayment(context.Background(), 1, "charge")
+	if err == nil {
+		t.Fatal("refund succeeded without a network")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "network is unreachable") || strings.Contains(msg, "secret-token") {
+		t.Errorf("error = %q, want the cause without the bot token", msg)
+	}
+}
@@ -0,0 +1,116 @@
+package tonapi
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is an exact token amount in minimal units (nanoTON or jetton units)
+type Amount struct {
+	Units    *big.Int
+	Decimals int
+}
+
+// TONAmount returns an Amount for a nanoTON value
+func TONAmount(nano int64) Amount {
+	return Amount{Units: big.NewInt(nano), Decimals: 9}
+}
+
+// JettonAmount parses jetton units into an Amount, invalid input yields zero
+func JettonAmount(units string, decimals int) Amount {
+	val, ok := new(big.Int).SetString(units, 10)
+	if !ok {
+		val = new(big.Int)
+	}
+	return Amount{Units: val, Decimals: decimals}
+}
+
+// DecimalAmount converts a decimal value such as a configured price to an exact
+// amount. The shortest decimal form of v is used, so 0.1 is exactly 0.1 and not
+// the nearest binary fraction; digits beyond decimals are rounded.
+func DecimalAmount(v float64, decimals int) Amount {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if _, frac, _ := strings.Cut(s, "."); len(frac) > decimals {
+		s = strconv.FormatFloat(v, 'f', decimals, 64)
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	return JettonAmount(whole+frac+strings.Repeat("0", decimals-len(frac)), decimals)
+}
+
+// IsZero reports whether the amount is zero
+func (a Amount) IsZero() bool {
+	return a.Units == nil || a.Units.Sign() == 0
+}
+
+// Cmp compares two amounts exactly, regardless of their decimals
+func (a Amount) Cmp(b Amount) int {
+	x, y, _ := a.align(b)
+	return x.Cmp(y)
+}
+
+// Add returns a + b with the larger of their decimals
+func (a Amount) Add(b Amount) Amount {
+	x, y, decimals := a.align(b)
+	return Amount{Units: new(big.Int).Add(x, y), Decimals: decimals}
+}
+
+// Sub returns a - b with the larger of their decimals
+func (a Amount) Sub(b Amount) Amount {
+	x, y, decimals := a.align(b)
+	return Amount{Units: new(big.Int).Sub(x, y), Decimals: decimals}
+}
+
+// align returns the units of a and b scaled to the larger of their decimals
+func (a Amount) align(b Amount) (x, y *big.Int, decimals int) {
+	x, y = a.units(), b.units()
+	switch {
+	case a.Decimals < b.Decimals:
+		return new(big.Int).Mul(x, Pow10(b.Decimals-a.Decimals)), y, b.Decimals
+	case a.Decimals > b.Decimals:
+		return x, new(big.Int).Mul(y, Pow10(a.Decimals-b.Decimals)), a.Decimals
+	}
+	return x, y, a.Decimals
+}
+
+// String returns the exact decimal value without trailing zeros, e.g. 2.5001
+func (a Amount) String() string {
+	digits := new(big.Int).Abs(a.units()).String()
+	sign := ""
+	if a.units().Sign() < 0 {
+		sign = "-"
+	}
+	if a.Decimals <= 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= a.Decimals {
+		digits = strings.Repeat("0", a.Decimals-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-a.Decimals], strings.TrimRight(digits[len(digits)-a.Decimals:], "0")
+	if frac == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + frac
+}
+
+// Float64 returns an approximate value, use it for rates and estimates only
+func (a Amount) Float64() float64 {
+	f := new(big.Float).SetInt(a.units())
+	f.Quo(f, new(big.Float).SetInt(Pow10(a.Decimals)))
+	val, _ := f.Float64()
+	return val
+}
+
+func (a Amount) units() *big.Int {
+	if a.Units == nil {
+		return new(big.Int)
+	}
+	return a.Units
+}
+
+// Pow10 returns 10^n as a big integer
+func Pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,91 @@
+package tonapi
+
+import "testing"
+
+func TestDecimalAmount(t *testing.T) {
+	tests := []struct {
+		value    float64
+		decimals int
+		want     string // units
+	}{
+		{value: 5, decimals: 9, want: "5000000000"},
+		{value: 2.5, decimals: 6, want: "2500000"},
+		{value: 0.1, decimals: 18, want: "100000000000000000"},
+		{value: 13.0001, decimals: 9, want: "13000100000"},
+		{value: 1.23456789, decimals: 4, want: "12346"},
+		{value: 250, decimals: 0, want: "250"},
+		{value: 0, decimals: 9, want: "0"},
+	}
+
+	for _, tt := range tests {
+		got := DecimalAmount(tt.value, tt.decimals)
+		if got.Units.String() != tt.want || got.Decimals != tt.decimals {
+			t.Errorf("DecimalAmount(%v, %d) = %s/%d, want %s", tt.value, tt.decimals, got.Units, got.Decimals, tt.want)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		want   string
+	}{
+		{amount: TONAmount(5_000_100_000), want: "5.0001"},
+		{amount: TONAmount(1), want: "0.000000001"},
+		{amount: TONAmount(0), want: "0"},
+		{amount: TONAmount(-1_500_000_000), want: "-1.5"},
+		{amount: JettonAmount("2500000", 6), want: "2.5"},
+		{amount: JettonAmount("250", 0), want: "250"},
+		{amount: JettonAmount("45000000000000000000", 18), want: "45"},
+		{amount: Amount{Decimals: 9}, want: "0"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.want {
+			t.Errorf("String(%s/%d) = %q, want %q", tt.amount.Units, tt.amount.Decimals, got, tt.want)
+		}
+	}
+}
+
+func TestAmountCmp(t *testing.T) {
+	tests := []struct {
+		a, b Amount
+		want int
+	}{
+		{a: TONAmount(1), b: TONAmount(1), want: 0},
+		{a: TONAmount(4_999_999_999), b: DecimalAmount(5, 9), want: -1},
+		{a: JettonAmount("2500000", 6), b: DecimalAmount(2.5, 9), want: 0},
+		{a: JettonAmount("2500001", 6), b: DecimalAmount(2.5, 9), want: 1},
+		{a: Amount{}, b: TONAmount(0), want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.a.Cmp(tt.b); got != tt.want {
+			t.Errorf("%s.Cmp(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestAmountAddSub(t *testing.T) {
+	tests := []struct {
+		a, b     Amount
+		sum      string
+		diff     string
+		decimals int
+	}{
+		{a: TONAmount(5_012_300_000), b: TONAmount(2_000_000_000), sum: "7.0123", diff: "3.0123", decimals: 9},
+		{a: JettonAmount("1500000", 6), b: DecimalAmount(0.5, 9), sum: "2", diff: "1", decimals: 9},
+		{a: TONAmount(1), b: TONAmount(2), sum: "0.000000003", diff: "-0.000000001", decimals: 9},
+		{a: Amount{}, b: JettonAmount("7", 0), sum: "7", diff: "-7", decimals: 0},
+	}
+
+	for _, tt := range tests {
+		sum, diff := tt.a.Add(tt.b), tt.a.Sub(tt.b)
+		if sum.String() != tt.sum || sum.Decimals != tt.decimals {
+			t.Errorf("%s + %s = %s (%d decimals), want %s (%d)", tt.a, tt.b, sum, sum.Decimals, tt.sum, tt.decimals)
+		}
+		if diff.String() != tt.diff || diff.Decimals != tt.decimals {
+			t.Errorf("%s - %s = %s (%d decimals), want %s (%d)", tt.a, tt.b, diff, diff.Decimals, tt.diff, tt.decimals)
+		}
+	}
+}
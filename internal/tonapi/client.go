@@ -4,14 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/suspectuso/ton-tracker/internal/metrics"
 	"github.com/tonkeeper/tongo/ton"
 )
 
@@ -22,66 +26,107 @@ type Client struct {
 	httpClient *http.Client
 
 	// Rate limiting
-	mu         sync.Mutex
-	lastCall   time.Time
-	minDelay   time.Duration
+	limiter    *rateLimiter
+	maxRetries int
 }
 
-// NewClient creates a new TonAPI client
-func NewClient(baseURL, apiKey string) *Client {
+const (
+	defaultRPS       = 1 // free tier without an API key
+	retryBaseBackoff = 500 * time.Millisecond
+	retryMaxBackoff  = 10 * time.Second
+)
+
+// NewClient creates a new TonAPI client.
+// rps is the request rate allowed by the API key tier.
+func NewClient(baseURL, apiKey string, rps float64) *Client {
+	if rps <= 0 {
+		rps = defaultRPS
+	}
+
 	return &Client{
 		baseURL: strings.TrimSuffix(baseURL, "/"),
 		apiKey:  apiKey,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		minDelay: 250 * time.Millisecond, // ~4 RPS
+		limiter:    newRateLimiter(rps, math.Max(1, rps)),
+		maxRetries: 3,
 	}
 }
 
-func (c *Client) throttle() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// doRequest sends a request, retrying rate limits, server errors and network
+// failures with jittered exponential backoff. Requests that aren't safe to
+// repeat (POST) are only retried when rate limited, as TonAPI didn't process them.
+func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}) ([]byte, error) {
+	var jsonData []byte
+	if body != nil {
+		var err error
+		jsonData, err = json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("marshal body: %w", err)
+		}
+	}
+
+	idempotent := method != http.MethodPost
+	for attempt := 0; ; attempt++ {
+		data, err := c.doOnce(ctx, method, path, jsonData)
+		if err == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil || attempt >= c.maxRetries {
+			return nil, err
+		}
+
+		var apiErr *APIError
+		isAPIErr := errors.As(err, &apiErr)
+		switch {
+		case errors.Is(err, ErrRateLimited):
+		case idempotent && (errors.Is(err, ErrServerError) || !isAPIErr):
+		default:
+			return nil, err
+		}
 
-	elapsed := time.Since(c.lastCall)
-	if elapsed < c.minDelay {
-		time.Sleep(c.minDelay - elapsed)
+		delay := retryBackoff(attempt)
+		if isAPIErr && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+		if err := sleepCtx(ctx, delay); err != nil {
+			return nil, err
+		}
 	}
-	c.lastCall = time.Now()
 }
 
-func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}) ([]byte, error) {
-	c.throttle()
-
-	url := c.baseURL + path
+func (c *Client) doOnce(ctx context.Context, method, path string, jsonData []byte) ([]byte, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
 
 	var reqBody io.Reader
-	if body != nil {
-		jsonData, err := json.Marshal(body)
-		if err != nil {
-			return nil, fmt.Errorf("marshal body: %w", err)
-		}
+	if jsonData != nil {
 		reqBody = bytes.NewReader(jsonData)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
 
 	req.Header.Set("Accept", "application/json")
-	if body != nil {
+	if jsonData != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
 	if c.apiKey != "" {
 		req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	}
 
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		metrics.TonAPIRequests.WithLabelValues(method, "error").Observe(metrics.Since(start))
 		return nil, fmt.Errorf("do request: %w", err)
 	}
 	defer resp.Body.Close()
+	metrics.TonAPIRequests.WithLabelValues(method, strconv.Itoa(resp.StatusCode)).Observe(metrics.Since(start))
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -89,12 +134,41 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 	}
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(data))
+		return nil, &APIError{
+			StatusCode: resp.StatusCode,
+			Body:       string(data),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
 	}
 
 	return data, nil
 }
 
+// retryBackoff returns a full-jitter exponential delay for a retry attempt
+func retryBackoff(attempt int) time.Duration {
+	ceiling := retryBaseBackoff << attempt
+	if ceiling > retryMaxBackoff || ceiling <= 0 {
+		ceiling = retryMaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(ceiling))) + time.Millisecond
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or HTTP-date form
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
 // GetAccountInfo returns account information
 func (c *Client) GetAccountInfo(ctx context.Context, address string) (*AccountInfo, error) {
 	data, err := c.doRequest(ctx, "GET", "/accounts/"+address, nil)
@@ -110,9 +184,50 @@ func (c *Client) GetAccountInfo(ctx context.Context, address string) (*AccountIn
 	return &info, nil
 }
 
+// eventsPageSize is the largest page TonAPI returns for account events
+const eventsPageSize = 100
+
 // GetEvents returns recent events for an account
 func (c *Client) GetEvents(ctx context.Context, address string, limit int) ([]Event, error) {
+	resp, err := c.getEventsPage(ctx, address, limit, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// IterateEvents walks account events with sinceLt < lt < untilLt, newest first,
+// following next_from across pages. Zero bounds are open. fn returns false to stop.
+func (c *Client) IterateEvents(ctx context.Context, address string, sinceLt, untilLt int64, fn func(ev *Event) bool) error {
+	beforeLt := untilLt
+	for {
+		resp, err := c.getEventsPage(ctx, address, eventsPageSize, beforeLt)
+		if err != nil {
+			return err
+		}
+
+		for i := range resp.Events {
+			ev := &resp.Events[i]
+			if sinceLt > 0 && ev.Lt <= sinceLt {
+				return nil
+			}
+			if !fn(ev) {
+				return nil
+			}
+		}
+
+		if resp.NextFrom == 0 || len(resp.Events) == 0 {
+			return nil
+		}
+		beforeLt = resp.NextFrom
+	}
+}
+
+func (c *Client) getEventsPage(ctx context.Context, address string, limit int, beforeLt int64) (*EventsResponse, error) {
 	path := fmt.Sprintf("/accounts/%s/events?limit=%d", address, limit)
+	if beforeLt > 0 {
+		path += fmt.Sprintf("&before_lt=%d", beforeLt)
+	}
 	data, err := c.doRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
@@ -123,7 +238,7 @@ func (c *Client) GetEvents(ctx context.Context, address string, limit int) ([]Ev
 		return nil, fmt.Errorf("unmarshal: %w", err)
 	}
 
-	return resp.Events, nil
+	return &resp, nil
 }
 
 // GetEventByHash returns an event by transaction hash
@@ -141,6 +256,74 @@ func (c *Client) GetEventByHash(ctx context.Context, txHash string) (*Event, err
 	return &event, nil
 }
 
+// GetJettonPriceTON returns the current price of one jetton in TON
+func (c *Client) GetJettonPriceTON(ctx context.Context, jettonAddr string) (float64, error) {
+	path := "/rates?tokens=" + url.QueryEscape(jettonAddr) + "&currencies=ton"
+	data, err := c.doRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var resp RatesResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return 0, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	// Only one token is requested, so the key format doesn't matter
+	for _, rates := range resp.Rates {
+		if price, ok := rates.Prices["TON"]; ok {
+			return price, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no TON rate for %s", jettonAddr)
+}
+
+// GetJettonInfo returns jetton metadata by master address
+func (c *Client) GetJettonInfo(ctx context.Context, master string) (*JettonInfo, error) {
+	data, err := c.doRequest(ctx, "GET", "/jettons/"+master, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp JettonResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	// Metadata follows TEP-64, where decimals is a string defaulting to 9
+	decimals := 9
+	if resp.Metadata.Decimals != "" {
+		decimals, err = strconv.Atoi(resp.Metadata.Decimals)
+		if err != nil {
+			return nil, fmt.Errorf("parse decimals: %w", err)
+		}
+	}
+
+	return &JettonInfo{
+		Address:  resp.Metadata.Address,
+		Name:     resp.Metadata.Name,
+		Symbol:   resp.Metadata.Symbol,
+		Decimals: decimals,
+		Image:    resp.Metadata.Image,
+	}, nil
+}
+
+// GetNftItem returns an NFT item by address
+func (c *Client) GetNftItem(ctx context.Context, address string) (*NftItem, error) {
+	data, err := c.doRequest(ctx, "GET", "/nfts/"+address, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var item NftItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	return &item, nil
+}
+
 // --- Webhook Management ---
 
 // ListWebhooks returns all webhooks
@@ -197,24 +380,54 @@ func (c *Client) UnsubscribeAccounts(ctx context.Context, webhookID int64, accou
 	return err
 }
 
+// subscriptionsPageSize is the page size for listing webhook subscriptions
+const subscriptionsPageSize = 1000
+
+// ListSubscribedAccounts returns all accounts subscribed to a webhook
+func (c *Client) ListSubscribedAccounts(ctx context.Context, webhookID int64) ([]string, error) {
+	var accounts []string
+	for offset := 0; ; offset += subscriptionsPageSize {
+		path := fmt.Sprintf("/webhooks/%d/account-tx/subscriptions?offset=%d&limit=%d", webhookID, offset, subscriptionsPageSize)
+		data, err := c.doRequest(ctx, "GET", path, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var resp AccountSubscriptionsResponse
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, fmt.Errorf("unmarshal: %w", err)
+		}
+
+		for _, sub := range resp.Subscriptions {
+			accounts = append(accounts, sub.AccountID)
+		}
+		if len(resp.Subscriptions) < subscriptionsPageSize {
+			return accounts, nil
+		}
+	}
+}
+
 // --- Address Utilities ---
 
-// NanoToTON converts nanoTON to TON
-func NanoToTON(nano int64) float64 {
-	return float64(nano) / 1e9
+// PriceToAmount converts a price to an exact amount.
+// TON prices without explicit decimals are treated as nanoTON.
+func PriceToAmount(p Price) Amount {
+	decimals := p.Decimals
+	if decimals == 0 && (p.TokenName == "" || p.TokenName == "TON") {
+		decimals = 9
+	}
+	return JettonAmount(p.Value, decimals)
 }
 
-// JettonUnitsToAmount converts jetton units to human-readable amount
-func JettonUnitsToAmount(units string, decimals int) float64 {
-	val, err := strconv.ParseInt(units, 10, 64)
-	if err != nil {
-		return 0
+// NftItemName returns a display name for an NFT item
+func NftItemName(item *NftItem) string {
+	if name, ok := item.Metadata["name"].(string); ok && name != "" {
+		return name
 	}
-	divisor := 1.0
-	for i := 0; i < decimals; i++ {
-		divisor *= 10
+	if item.DNS != "" {
+		return item.DNS
 	}
-	return float64(val) / divisor
+	return fmt.Sprintf("#%d", item.Index)
 }
 
 // RawToFriendly converts raw address (0:...) to friendly format (UQ.../EQ...)
@@ -248,6 +461,19 @@ func NormalizeAddress(addr string) string {
 	return acc.String()
 }
 
+// ValidAddress reports whether addr is a complete raw or friendly address.
+// Raw addresses must carry all 64 hex digits, tongo pads shorter ones.
+func ValidAddress(addr string) bool {
+	acc, err := ton.ParseAccountID(addr)
+	if err != nil {
+		return false
+	}
+	if strings.Contains(addr, ":") {
+		return acc.String() == strings.ToLower(addr)
+	}
+	return true
+}
+
 // ShortAddr returns a shortened address for display
 func ShortAddr(addr string, n int) string {
 	if addr == "" {
@@ -0,0 +1,192 @@
+package tonapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newEventsServer serves events with the given lts newest first in pages of
+// pageSize, and records the before_lt of every request
+func newEventsServer(t *testing.T, lts []int64, pageSize int, requests *[]int64) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		beforeLt, _ := strconv.ParseInt(r.URL.Query().Get("before_lt"), 10, 64)
+		*requests = append(*requests, beforeLt)
+
+		var resp EventsResponse
+		for _, lt := range lts {
+			if beforeLt > 0 && lt >= beforeLt {
+				continue
+			}
+			if len(resp.Events) == pageSize {
+				resp.NextFrom = resp.Events[len(resp.Events)-1].Lt
+				break
+			}
+			resp.Events = append(resp.Events, Event{Lt: lt})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	return NewClient(srv.URL, "", 1000)
+}
+
+func TestIterateEvents(t *testing.T) {
+	lts := []int64{90, 80, 70, 60, 50, 40, 30, 20, 10}
+
+	tests := []struct {
+		name         string
+		sinceLt      int64
+		untilLt      int64
+		stopAfter    int // fn returns false after this many events, 0 never
+		wantLts      []int64
+		wantRequests []int64
+	}{
+		{
+			name:         "open bounds walk all pages",
+			wantLts:      lts,
+			wantRequests: []int64{0, 70, 40},
+		},
+		{
+			name:         "since is exclusive and stops paging",
+			sinceLt:      50,
+			wantLts:      []int64{90, 80, 70, 60},
+			wantRequests: []int64{0, 70},
+		},
+		{
+			name:         "until is exclusive",
+			untilLt:      80,
+			sinceLt:      40,
+			wantLts:      []int64{70, 60, 50},
+			wantRequests: []int64{80, 50},
+		},
+		{
+			name:         "since on a page boundary",
+			sinceLt:      60,
+			wantLts:      []int64{90, 80, 70},
+			wantRequests: []int64{0, 70},
+		},
+		{
+			name:         "callback stops early",
+			stopAfter:    2,
+			wantLts:      []int64{90, 80},
+			wantRequests: []int64{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []int64
+			client := newEventsServer(t, lts, 3, &requests)
+
+			var got []int64
+			err := client.IterateEvents(context.Background(), "0:abc", tt.sinceLt, tt.untilLt, func(ev *Event) bool {
+				got = append(got, ev.Lt)
+				return tt.stopAfter == 0 || len(got) < tt.stopAfter
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.wantLts) {
+				t.Errorf("events = %v, want %v", got, tt.wantLts)
+			}
+			if !slices.Equal(requests, tt.wantRequests) {
+				t.Errorf("before_lt of requests = %v, want %v", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestDoRequestRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		statuses   []int // response status per attempt, 200 after the list
+		retryAfter string
+		wantErr    error
+		wantCalls  int
+		minElapsed time.Duration
+	}{
+		{
+			name:       "rate limit honours Retry-After",
+			method:     http.MethodGet,
+			statuses:   []int{http.StatusTooManyRequests},
+			retryAfter: "1",
+			wantCalls:  2,
+			minElapsed: time.Second,
+		},
+		{
+			name:      "server error retried for GET",
+			method:    http.MethodGet,
+			statuses:  []int{http.StatusBadGateway, http.StatusServiceUnavailable},
+			wantCalls: 3,
+		},
+		{
+			name:      "server error not retried for POST",
+			method:    http.MethodPost,
+			statuses:  []int{http.StatusInternalServerError},
+			wantErr:   ErrServerError,
+			wantCalls: 1,
+		},
+		{
+			name:      "rate limit retried for POST",
+			method:    http.MethodPost,
+			statuses:  []int{http.StatusTooManyRequests},
+			wantCalls: 2,
+		},
+		{
+			name:      "not found is final",
+			method:    http.MethodGet,
+			statuses:  []int{http.StatusNotFound},
+			wantErr:   ErrNotFound,
+			wantCalls: 1,
+		},
+		{
+			name:      "gives up after max retries",
+			method:    http.MethodGet,
+			statuses:  []int{500, 500, 500, 500, 500},
+			wantErr:   ErrServerError,
+			wantCalls: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls <= len(tt.statuses) {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(tt.statuses[calls-1])
+					return
+				}
+				w.Write([]byte("{}"))
+			}))
+			defer srv.Close()
+
+			client := NewClient(srv.URL, "", 1000)
+			start := time.Now()
+			_, err := client.doRequest(context.Background(), tt.method, "/test", nil)
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minElapsed {
+				t.Errorf("retried after %v, want at least %v", elapsed, tt.minElapsed)
+			}
+		})
+	}
+}
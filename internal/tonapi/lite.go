@@ -0,0 +1,201 @@
+package tonapi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/config"
+	"github.com/tonkeeper/tongo/liteapi"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+// litePageSize is the number of transactions requested from a liteserver at once
+const litePageSize = 16
+
+// liteEventPrefix marks event IDs made from a single transaction hash. TonAPI
+// event IDs are trace IDs, so the two never match and dedup of lite events
+// only works against other lite events. Readers resume from the account
+// cursor, which both sources advance by lt, so they don't read the same
+// transactions twice; only a stream catch-up through a liteserver can overlap
+// live TonAPI events.
+const liteEventPrefix = "lite:"
+
+// LiteClient reads account transactions directly from TON liteservers.
+// Liteservers return raw transactions, so only TON transfers are decoded into
+// actions; jetton, NFT and swap parsing needs TonAPI.
+type LiteClient struct {
+	client *liteapi.Client
+}
+
+// NewLiteClient connects to liteservers. servers is "mainnet" for the public
+// mainnet config or a list in tongo's LITE_SERVERS format ("ip:port:key,...").
+func NewLiteClient(servers string) (*LiteClient, error) {
+	var (
+		client *liteapi.Client
+		err    error
+	)
+
+	if strings.EqualFold(servers, "mainnet") {
+		client, err = liteapi.NewClientWithDefaultMainnet()
+	} else {
+		var list []config.LiteServer
+		list, err = config.ParseLiteServersEnvVar(servers)
+		if err != nil {
+			return nil, fmt.Errorf("parse lite servers: %w", err)
+		}
+		client, err = liteapi.NewClient(liteapi.WithLiteServers(list))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect lite servers: %w", err)
+	}
+
+	return &LiteClient{client: client}, nil
+}
+
+// GetEvents returns recent events for an account, one per transaction
+func (l *LiteClient) GetEvents(ctx context.Context, address string, limit int) ([]Event, error) {
+	var events []Event
+	err := l.IterateEvents(ctx, address, 0, 0, func(ev *Event) bool {
+		events = append(events, *ev)
+		return len(events) < limit
+	})
+	return events, err
+}
+
+// IterateEvents walks account transactions with sinceLt < lt < untilLt, newest
+// first, with the same semantics as Client.IterateEvents
+func (l *LiteClient) IterateEvents(ctx context.Context, address string, sinceLt, untilLt int64, fn func(ev *Event) bool) error {
+	account, err := ton.ParseAccountID(address)
+	if err != nil {
+		return fmt.Errorf("parse address: %w", err)
+	}
+
+	// Transactions are linked newest to oldest, start from the last one
+	state, err := l.client.GetAccountState(ctx, account)
+	if err != nil {
+		return fmt.Errorf("get account state: %w", err)
+	}
+	lt, hash := state.LastTransLt, state.LastTransHash
+
+	for lt != 0 {
+		txs, err := l.client.GetTransactions(ctx, litePageSize, account, lt, ton.Bits256(hash))
+		if err != nil {
+			return fmt.Errorf("get transactions: %w", err)
+		}
+		if len(txs) == 0 {
+			return nil
+		}
+
+		for i := range txs {
+			tx := &txs[i]
+			if sinceLt > 0 && int64(tx.Lt) <= sinceLt {
+				return nil
+			}
+			if untilLt > 0 && int64(tx.Lt) >= untilLt {
+				continue
+			}
+
+			ev := transactionToEvent(tx)
+			if !fn(&ev) {
+				return nil
+			}
+		}
+
+		last := txs[len(txs)-1]
+		lt, hash = last.PrevTransLt, last.PrevTransHash
+	}
+
+	return nil
+}
+
+// transactionToEvent converts a raw transaction into an event with TonTransfer actions
+func transactionToEvent(tx *ton.Transaction) Event {
+	ev := Event{
+		EventID:   liteEventPrefix + tx.Hash().Hex(),
+		Timestamp: int64(tx.Now),
+		Lt:        int64(tx.Lt),
+	}
+
+	if tx.Msgs.InMsg.Exists {
+		if action, ok := transferAction(&tx.Msgs.InMsg.Value.Value); ok {
+			ev.Actions = append(ev.Actions, action)
+		}
+	}
+	for _, out := range tx.Msgs.OutMsgs.Values() {
+		if action, ok := transferAction(&out.Value); ok {
+			ev.Actions = append(ev.Actions, action)
+		}
+	}
+
+	return ev
+}
+
+// transferAction returns a TonTransfer for an internal message carrying TON
+// with an empty or text comment body. Other bodies are contract calls such as
+// jetton or NFT transfers, which only TonAPI decodes.
+func transferAction(msg *tlb.Message) (Action, bool) {
+	info := msg.Info.IntMsgInfo
+	if msg.Info.SumType != "IntMsgInfo" || info == nil || info.Bounced || info.Value.Grams == 0 {
+		return Action{}, false
+	}
+
+	body := boc.Cell(msg.Body.Value)
+	comment, ok := bodyComment(&body)
+	if !ok {
+		return Action{}, false
+	}
+
+	return Action{
+		Type:   "TonTransfer",
+		Status: "ok",
+		TonTransfer: &TonTransfer{
+			Sender:    Account{Address: rawAddress(info.Src)},
+			Recipient: Account{Address: rawAddress(info.Dest)},
+			Amount:    int64(info.Value.Grams),
+			Comment:   comment,
+		},
+	}, true
+}
+
+func rawAddress(addr tlb.MsgAddress) string {
+	id, err := ton.AccountIDFromTlb(addr)
+	if err != nil || id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+// bodyComment decodes a plain transfer body: empty, or a text comment
+// (op 0 followed by a snake string). ok is false for any other op.
+// Invalid UTF-8 is dropped from the comment.
+func bodyComment(cell *boc.Cell) (comment string, ok bool) {
+	cell.ResetCounters()
+	if cell.BitsAvailableForRead() < 32 {
+		return "", cell.RefsSize() == 0
+	}
+
+	op, err := cell.ReadUint(32)
+	if err != nil || op != 0 {
+		return "", false
+	}
+
+	var sb strings.Builder
+	c := cell
+	for c != nil {
+		data, err := c.ReadBytes(c.BitsAvailableForRead() / 8)
+		if err != nil {
+			break
+		}
+		sb.Write(data)
+
+		c, err = c.NextRef()
+		if err != nil {
+			break
+		}
+	}
+
+	return strings.ToValidUTF8(sb.String(), ""), true
+}
@@ -0,0 +1,56 @@
+package tonapi
+
+import (
+	"testing"
+
+	"github.com/tonkeeper/tongo/boc"
+)
+
+// testBody builds a message body with an op and data, continued in a ref when tail is set
+func testBody(t *testing.T, op uint64, data, tail []byte) *boc.Cell {
+	t.Helper()
+	cell := boc.NewCell()
+	if err := cell.WriteUint(op, 32); err != nil {
+		t.Fatal(err)
+	}
+	if err := cell.WriteBytes(data); err != nil {
+		t.Fatal(err)
+	}
+	if tail != nil {
+		next := boc.NewCell()
+		if err := next.WriteBytes(tail); err != nil {
+			t.Fatal(err)
+		}
+		if err := cell.AddRef(next); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cell
+}
+
+func TestBodyComment(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        *boc.Cell
+		wantComment string
+		wantOK      bool
+	}{
+		{name: "empty body", body: boc.NewCell(), wantOK: true},
+		{name: "comment", body: testBody(t, 0, []byte("hello"), nil), wantComment: "hello", wantOK: true},
+		{name: "snake comment", body: testBody(t, 0, []byte("hello "), []byte("world")), wantComment: "hello world", wantOK: true},
+		{name: "empty comment", body: testBody(t, 0, nil, nil), wantOK: true},
+		{name: "invalid utf-8", body: testBody(t, 0, []byte("ok\xff\xfe"), nil), wantComment: "ok", wantOK: true},
+		{name: "jetton transfer", body: testBody(t, 0x0f8a7ea5, []byte{1, 2, 3}, nil)},
+		{name: "nft transfer", body: testBody(t, 0x5fcc3d14, nil, nil)},
+		{name: "encrypted comment", body: testBody(t, 0x2167da4b, []byte("secret"), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comment, ok := bodyComment(tt.body)
+			if comment != tt.wantComment || ok != tt.wantOK {
+				t.Errorf("bodyComment = %q, %v, want %q, %v", comment, ok, tt.wantComment, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package tonapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		burst    float64
+		requests int
+		minWait  time.Duration
+		maxWait  time.Duration
+	}{
+		{name: "within burst", rate: 10, burst: 3, requests: 3, maxWait: 20 * time.Millisecond},
+		{name: "over burst", rate: 20, burst: 1, requests: 3, minWait: 90 * time.Millisecond, maxWait: 200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.rate, tt.burst)
+
+			start := time.Now()
+			for i := 0; i < tt.requests; i++ {
+				if err := l.Wait(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			elapsed := time.Since(start)
+
+			if elapsed < tt.minWait || elapsed > tt.maxWait {
+				t.Errorf("%d requests took %v, want %v..%v", tt.requests, elapsed, tt.minWait, tt.maxWait)
+			}
+		})
+	}
+}
+
+func TestRateLimiterCancelReturnsToken(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err == nil {
+		t.Fatal("Wait on an empty bucket returned before the deadline")
+	}
+
+	// The cancelled reservation is given back, so the bucket is not further in debt
+	l.mu.Lock()
+	tokens := l.tokens
+	l.mu.Unlock()
+	if tokens < -0.1 {
+		t.Errorf("tokens after cancel = %.2f, want about 0", tokens)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{value: "", min: 0, max: 0},
+		{value: "3", min: 3 * time.Second, max: 3 * time.Second},
+		{value: "0", min: 0, max: 0},
+		{value: "soon", min: 0, max: 0},
+		{value: time.Now().Add(5 * time.Second).UTC().Format(http.TimeFormat), min: 3 * time.Second, max: 5 * time.Second},
+		{value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), min: 0, max: 0},
+	}
+
+	for _, tt := range tests {
+		got := parseRetryAfter(tt.value)
+		if got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v..%v", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
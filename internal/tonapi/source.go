@@ -0,0 +1,71 @@
+package tonapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// EventSource provides account events, implemented by Client and LiteClient
+type EventSource interface {
+	GetEvents(ctx context.Context, address string, limit int) ([]Event, error)
+	IterateEvents(ctx context.Context, address string, sinceLt, untilLt int64, fn func(ev *Event) bool) error
+}
+
+// FallbackSource reads events from a primary source and switches to a
+// secondary one while the primary is unavailable or rate limited
+type FallbackSource struct {
+	primary   EventSource
+	secondary EventSource
+	log       *slog.Logger
+}
+
+// NewFallbackSource creates a new fallback event source
+func NewFallbackSource(primary, secondary EventSource, log *slog.Logger) *FallbackSource {
+	return &FallbackSource{
+		primary:   primary,
+		secondary: secondary,
+		log:       log,
+	}
+}
+
+// GetEvents returns recent events for an account
+func (f *FallbackSource) GetEvents(ctx context.Context, address string, limit int) ([]Event, error) {
+	events, err := f.primary.GetEvents(ctx, address, limit)
+	if err == nil || !f.shouldFallback(ctx, err) {
+		return events, err
+	}
+
+	f.log.Warn("primary event source failed, using fallback", "address", address, "error", err)
+	return f.secondary.GetEvents(ctx, address, limit)
+}
+
+// IterateEvents walks account events, continuing from the secondary source
+// below the last event seen if the primary fails midway
+func (f *FallbackSource) IterateEvents(ctx context.Context, address string, sinceLt, untilLt int64, fn func(ev *Event) bool) error {
+	lastLt := untilLt
+	err := f.primary.IterateEvents(ctx, address, sinceLt, untilLt, func(ev *Event) bool {
+		lastLt = ev.Lt
+		return fn(ev)
+	})
+	if err == nil || !f.shouldFallback(ctx, err) {
+		return err
+	}
+
+	f.log.Warn("primary event source failed, using fallback", "address", address, "error", err)
+	return f.secondary.IterateEvents(ctx, address, sinceLt, lastLt, fn)
+}
+
+// shouldFallback reports whether err means the primary source is unavailable,
+// as opposed to a bad request the secondary would reject too
+func (f *FallbackSource) shouldFallback(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return true // network error
+	}
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServerError) || errors.Is(err, ErrUnauthorized)
+}
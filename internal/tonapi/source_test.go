@@ -0,0 +1,119 @@
+package tonapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+)
+
+// fakeSource serves events newest first and fails after failAfter events when failErr is set
+type fakeSource struct {
+	lts       []int64 // newest first
+	failAfter int
+	failErr   error
+
+	calls [][2]int64 // sinceLt, untilLt of each IterateEvents call
+}
+
+func (f *fakeSource) GetEvents(ctx context.Context, address string, limit int) ([]Event, error) {
+	var events []Event
+	err := f.IterateEvents(ctx, address, 0, 0, func(ev *Event) bool {
+		events = append(events, *ev)
+		return len(events) < limit
+	})
+	return events, err
+}
+
+func (f *fakeSource) IterateEvents(ctx context.Context, address string, sinceLt, untilLt int64, fn func(ev *Event) bool) error {
+	f.calls = append(f.calls, [2]int64{sinceLt, untilLt})
+
+	sent := 0
+	for _, lt := range f.lts {
+		if untilLt > 0 && lt >= untilLt {
+			continue
+		}
+		if lt <= sinceLt {
+			return nil
+		}
+		if f.failErr != nil && sent == f.failAfter {
+			return f.failErr
+		}
+		sent++
+		if !fn(&Event{Lt: lt}) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func TestFallbackSourceIterateEvents(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	lts := []int64{50, 40, 30, 20, 10}
+
+	tests := []struct {
+		name          string
+		primaryErr    error
+		failAfter     int
+		wantLts       []int64
+		wantErr       bool
+		wantSecondary [][2]int64
+	}{
+		{
+			name:    "primary ok",
+			wantLts: []int64{50, 40, 30},
+		},
+		{
+			name:          "network error resumes below last seen lt",
+			primaryErr:    errors.New("connection reset"),
+			failAfter:     1,
+			wantLts:       []int64{50, 40, 30},
+			wantSecondary: [][2]int64{{25, 50}},
+		},
+		{
+			name:          "rate limited before any event",
+			primaryErr:    &APIError{StatusCode: 429},
+			wantLts:       []int64{50, 40, 30},
+			wantSecondary: [][2]int64{{25, 0}},
+		},
+		{
+			name:       "bad request is not retried",
+			primaryErr: &APIError{StatusCode: 400},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &fakeSource{lts: lts, failAfter: tt.failAfter, failErr: tt.primaryErr}
+			secondary := &fakeSource{lts: lts}
+			src := NewFallbackSource(primary, secondary, log)
+
+			var got []int64
+			err := src.IterateEvents(context.Background(), "0:abc", 25, 0, func(ev *Event) bool {
+				got = append(got, ev.Lt)
+				return true
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !slices.Equal(got, tt.wantLts) {
+				t.Errorf("events = %v, want %v", got, tt.wantLts)
+			}
+			if len(secondary.calls) != len(tt.wantSecondary) {
+				t.Fatalf("secondary calls = %v, want %v", secondary.calls, tt.wantSecondary)
+			}
+			for i := range tt.wantSecondary {
+				if secondary.calls[i] != tt.wantSecondary[i] {
+					t.Errorf("secondary call %d = %v, want %v", i, secondary.calls[i], tt.wantSecondary[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package tonapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// streamChunkSize bounds the accounts per stream to keep the request URL short
+	streamChunkSize = 100
+
+	// streamIdleTimeout drops a stream that sends neither transactions nor heartbeats
+	streamIdleTimeout = time.Minute
+
+	// A stream that stayed up this long resets the reconnect backoff
+	streamStableAfter   = time.Minute
+	streamBackoffMin    = time.Second
+	streamBackoffMax    = time.Minute
+	streamMaxEventBytes = 1 << 20
+)
+
+var errStreamIdle = errors.New("stream idle")
+
+// Subscriber keeps TonAPI SSE streams of account transactions open for a set of
+// accounts. Streams reconnect with backoff, when the set changes only streams
+// whose accounts changed are reopened.
+type Subscriber struct {
+	client     *Client
+	httpClient *http.Client
+	log        *slog.Logger
+	chunkSize  int
+
+	mu       sync.Mutex
+	accounts []string
+	changed  chan struct{}
+}
+
+// openStream is a running stream of one chunk of accounts
+type openStream struct {
+	accounts []string
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func (o *openStream) stop() {
+	o.cancel()
+	<-o.done
+}
+
+// NewSubscriber creates a new SSE subscriber using the client's base URL and API key
+func NewSubscriber(client *Client, log *slog.Logger) *Subscriber {
+	return &Subscriber{
+		client: client,
+		// No timeout, streams are long-lived and guarded by streamIdleTimeout
+		httpClient: &http.Client{},
+		log:        log,
+		chunkSize:  streamChunkSize,
+		changed:    make(chan struct{}, 1),
+	}
+}
+
+// SetAccounts replaces the subscribed accounts, streams of changed chunks are reopened
+func (s *Subscriber) SetAccounts(accounts []string) {
+	accounts = slices.Clone(accounts)
+	slices.Sort(accounts)
+	accounts = slices.Compact(accounts)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.Equal(accounts, s.accounts) {
+		return
+	}
+	s.accounts = accounts
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run streams transactions until ctx is cancelled. onTx receives the same
+// account_id/lt/tx_hash fields as a webhook call. onReconnect is called with
+// the accounts of a stream reopened after a failure or an account change,
+// so the gap can be caught up. It runs before the stream delivers any
+// transaction and blocks it, so it should only note where to catch up from.
+func (s *Subscriber) Run(ctx context.Context, onTx func(WebhookPayload), onReconnect func(ctx context.Context, accounts []string)) {
+	var streams []*openStream
+	for {
+		s.mu.Lock()
+		accounts := s.accounts
+		s.mu.Unlock()
+
+		current := make([][]string, len(streams))
+		for i, st := range streams {
+			current[i] = st.accounts
+		}
+
+		var next []*openStream
+		opened, reopened := 0, 0
+		for i, chunk := range assignChunks(current, accounts, s.chunkSize) {
+			var old *openStream
+			if i < len(streams) {
+				old = streams[i]
+				if slices.Equal(old.accounts, chunk) {
+					next = append(next, old)
+					continue
+				}
+				old.stop()
+			}
+			if len(chunk) == 0 {
+				continue
+			}
+
+			next = append(next, s.openStream(ctx, chunk, old != nil, onTx, onReconnect))
+			if old != nil {
+				reopened++
+			} else {
+				opened++
+			}
+		}
+		streams = next
+
+		if opened+reopened > 0 {
+			s.log.Info("sse streams updated",
+				"accounts", len(accounts),
+				"streams", len(streams),
+				"opened", opened,
+				"reopened", reopened,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, st := range streams {
+				st.stop()
+			}
+			return
+		case <-s.changed:
+		}
+	}
+}
+
+// assignChunks splits accounts into streams of up to size accounts, keeping
+// existing chunks stable: chunk i of the result is current[i] without removed
+// accounts, possibly topped up with added ones. Added accounts that don't fit
+// go into new chunks at the end. Emptied chunks are returned empty.
+func assignChunks(current [][]string, accounts []string, size int) [][]string {
+	wanted := make(map[string]bool, len(accounts))
+	for _, addr := range accounts {
+		wanted[addr] = true
+	}
+
+	next := make([][]string, len(current))
+	assigned := make(map[string]bool, len(accounts))
+	for i, chunk := range current {
+		for _, addr := range chunk {
+			if wanted[addr] {
+				next[i] = append(next[i], addr)
+				assigned[addr] = true
+			}
+		}
+	}
+
+	i := 0
+	for _, addr := range accounts {
+		if assigned[addr] {
+			continue
+		}
+		for i < len(next) && len(next[i]) >= size {
+			i++
+		}
+		if i == len(next) {
+			next = append(next, nil)
+		}
+		next[i] = append(next[i], addr)
+	}
+
+	return next
+}
+
+// openStream starts a stream for a chunk of accounts.
+// A reopened chunk is caught up once connected, as events may have arrived in between.
+func (s *Subscriber) openStream(ctx context.Context, accounts []string, reopened bool, onTx func(WebhookPayload), onReconnect func(ctx context.Context, accounts []string)) *openStream {
+	ctx, cancel := context.WithCancel(ctx)
+	st := &openStream{
+		accounts: accounts,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		defer close(st.done)
+		s.runStream(ctx, accounts, reopened, onTx, onReconnect)
+	}()
+	return st
+}
+
+// runStream keeps one stream open, reconnecting with backoff.
+// With catchUp set, onReconnect also runs after the first connect.
+func (s *Subscriber) runStream(ctx context.Context, accounts []string, catchUp bool, onTx func(WebhookPayload), onReconnect func(ctx context.Context, accounts []string)) {
+	backoff := streamBackoffMin
+	failed := catchUp
+
+	for {
+		started := time.Now()
+		err := s.stream(ctx, accounts, onTx, func() {
+			if failed && onReconnect != nil {
+				onReconnect(ctx, accounts)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > streamStableAfter {
+			backoff = streamBackoffMin
+		}
+
+		delay := backoff
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+
+		s.log.Warn("sse stream closed, reconnecting",
+			"accounts", len(accounts),
+			"error", err,
+			"delay", delay,
+		)
+		failed = true
+
+		if err := sleepCtx(ctx, delay); err != nil {
+			return
+		}
+		backoff = min(backoff*2, streamBackoffMax)
+	}
+}
+
+// stream reads one SSE connection until it fails, connected is called once the server accepts it
+func (s *Subscriber) stream(ctx context.Context, accounts []string, onTx func(WebhookPayload), connected func()) error {
+	if err := s.client.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	u := s.client.baseURL + "/sse/accounts/transactions?accounts=" + url.QueryEscape(strings.Join(accounts, ","))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+	if s.client.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.client.apiKey)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Body:       string(data),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	connected()
+
+	idle := time.AfterFunc(streamIdleTimeout, func() { cancel(errStreamIdle) })
+	defer idle.Stop()
+
+	err = readSSE(resp.Body, func() { idle.Reset(streamIdleTimeout) }, func(data string) {
+		var payload WebhookPayload
+		if err := json.Unmarshal([]byte(data), &payload); err != nil {
+			s.log.Warn("invalid sse event", "error", err)
+		} else if payload.AccountID != "" {
+			onTx(payload)
+		}
+	})
+
+	if cause := context.Cause(ctx); errors.Is(cause, errStreamIdle) {
+		return errStreamIdle
+	}
+	if err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// readSSE reads server-sent events until r ends and calls fn with the data
+// of every message event. touch is called for each line, heartbeats included.
+func readSSE(r io.Reader, touch func(), fn func(data string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), streamMaxEventBytes)
+
+	var event string
+	var data strings.Builder
+	for scanner.Scan() {
+		touch()
+		line := scanner.Text()
+
+		switch {
+		case line == "":
+			// Blank line ends an event
+			if (event == "" || event == "message") && data.Len() > 0 {
+				fn(data.String())
+			}
+			event = ""
+			data.Reset()
+		case strings.HasPrefix(line, ":"):
+			// Comment
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,212 @@
+package tonapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadSSE(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "single message",
+			input: "data: {\"a\":1}\n\n",
+			want:  []string{`{"a":1}`},
+		},
+		{
+			name:  "named message event and comments",
+			input: ": heartbeat\nevent: message\ndata: one\n\n:\n\ndata: two\n\n",
+			want:  []string{"one", "two"},
+		},
+		{
+			name:  "multi-line data is joined",
+			input: "data: first\ndata:second\n\n",
+			want:  []string{"first\nsecond"},
+		},
+		{
+			name:  "other events are skipped",
+			input: "event: heartbeat\ndata: ping\n\ndata: tx\n\n",
+			want:  []string{"tx"},
+		},
+		{
+			name:  "unterminated event is dropped",
+			input: "data: done\n\ndata: partial",
+			want:  []string{"done"},
+		},
+		{
+			name:  "empty data is skipped",
+			input: "data:\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			lines := 0
+			err := readSSE(strings.NewReader(tt.input), func() { lines++ }, func(data string) {
+				got = append(got, data)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("events = %q, want %q", got, tt.want)
+			}
+			if want := len(strings.Split(strings.TrimSuffix(tt.input, "\n"), "\n")); lines != want {
+				t.Errorf("touch called %d times for %d lines", lines, want)
+			}
+		})
+	}
+}
+
+func TestAssignChunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  [][]string
+		accounts []string
+		want     [][]string
+	}{
+		{
+			name:     "initial split",
+			accounts: []string{"a", "b", "c", "d", "e"},
+			want:     [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		{
+			name:     "unchanged",
+			current:  [][]string{{"a", "b"}, {"c"}},
+			accounts: []string{"a", "b", "c"},
+			want:     [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name:     "added account tops up a chunk with room",
+			current:  [][]string{{"a", "c"}, {"e"}},
+			accounts: []string{"a", "b", "c", "e"},
+			want:     [][]string{{"a", "c"}, {"e", "b"}},
+		},
+		{
+			name:     "removed account only changes its chunk",
+			current:  [][]string{{"a", "b"}, {"c", "d"}},
+			accounts: []string{"a", "b", "d"},
+			want:     [][]string{{"a", "b"}, {"d"}},
+		},
+		{
+			name:     "emptied chunk stays in place",
+			current:  [][]string{{"a", "b"}, {"c"}, {"d", "e"}},
+			accounts: []string{"a", "b", "d", "e"},
+			want:     [][]string{{"a", "b"}, nil, {"d", "e"}},
+		},
+		{
+			name:     "freed room is reused",
+			current:  [][]string{{"a", "b"}, {"c", "d"}},
+			accounts: []string{"b", "c", "d", "x", "y"},
+			want:     [][]string{{"b", "x"}, {"c", "d"}, {"y"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := assignChunks(tt.current, tt.accounts, 2)
+			if len(got) != len(tt.want) {
+				t.Fatalf("chunks = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if !slices.Equal(got[i], tt.want[i]) {
+					t.Errorf("chunks = %q, want %q", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSubscriberReopensChangedChunks(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns = make(map[string]int)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accounts := r.URL.Query().Get("accounts")
+		mu.Lock()
+		conns[accounts]++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"account_id\":%q,\"lt\":1}\n\n", strings.Split(accounts, ",")[0])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	s := NewSubscriber(NewClient(srv.URL, "", 1000), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.chunkSize = 2
+
+	txs := make(chan WebhookPayload, 10)
+	caughtUp := make(chan []string, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.SetAccounts([]string{"a", "b", "c"})
+	go func() {
+		s.Run(ctx, func(p WebhookPayload) { txs <- p }, func(ctx context.Context, accounts []string) {
+			caughtUp <- accounts
+		})
+		close(done)
+	}()
+
+	// waitTx waits for a streamed transaction of the account, any account if empty
+	waitTx := func(want string) {
+		t.Helper()
+		for {
+			select {
+			case p := <-txs:
+				if want == "" || p.AccountID == want {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("no transaction for %q", want)
+			}
+		}
+	}
+	waitTx("")
+	waitTx("")
+
+	// d joins the chunk of c, the chunk of a and b is left open
+	s.SetAccounts([]string{"a", "b", "c", "d"})
+	waitTx("c")
+
+	select {
+	case accounts := <-caughtUp:
+		if !slices.Equal(accounts, []string{"c", "d"}) {
+			t.Errorf("caught up %v, want [c d]", accounts)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("reopened chunk was not caught up")
+	}
+
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]int{"a,b": 1, "c": 1, "c,d": 1}
+	for accounts, n := range want {
+		if conns[accounts] != n {
+			t.Errorf("connections for %s = %d, want %d (all: %v)", accounts, conns[accounts], n, conns)
+		}
+	}
+	if len(caughtUp) != 0 {
+		t.Errorf("unexpected catch-up of %v", <-caughtUp)
+	}
+}
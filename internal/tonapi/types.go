@@ -2,18 +2,29 @@ package tonapi
 
 // Event represents a TonAPI event
 type Event struct {
-	EventID   string   `json:"event_id"`
-	Timestamp int64    `json:"timestamp"`
-	Actions   []Action `json:"actions"`
-	IsScam    bool     `json:"is_scam"`
+	EventID    string   `json:"event_id"`
+	Timestamp  int64    `json:"timestamp"`
+	Lt         int64    `json:"lt"`
+	InProgress bool     `json:"in_progress"`
+	Actions    []Action `json:"actions"`
+	IsScam     bool     `json:"is_scam"`
 }
 
 // Action represents an action within an event
 type Action struct {
-	Type        string       `json:"type"`
-	Status      string       `json:"status"`
-	TonTransfer *TonTransfer `json:"TonTransfer,omitempty"`
-	JettonSwap  *JettonSwap  `json:"JettonSwap,omitempty"`
+	Type            string           `json:"type"`
+	Status          string           `json:"status"`
+	TonTransfer     *TonTransfer     `json:"TonTransfer,omitempty"`
+	JettonTransfer  *JettonTransfer  `json:"JettonTransfer,omitempty"`
+	JettonSwap      *JettonSwap      `json:"JettonSwap,omitempty"`
+	NftItemTransfer *NftItemTransfer `json:"NftItemTransfer,omitempty"`
+	NftPurchase     *NftPurchase     `json:"NftPurchase,omitempty"`
+
+	DepositStake         *Stake `json:"DepositStake,omitempty"`
+	WithdrawStake        *Stake `json:"WithdrawStake,omitempty"`
+	WithdrawStakeRequest *Stake `json:"WithdrawStakeRequest,omitempty"`
+
+	SmartContractExec *SmartContractExec `json:"SmartContractExec,omitempty"`
 }
 
 // TonTransfer represents a TON transfer action
@@ -24,6 +35,18 @@ type TonTransfer struct {
 	Comment   string  `json:"comment,omitempty"`
 }
 
+// JettonTransfer represents a jetton transfer action.
+// Sender is nil for mints and Recipient is nil for burns.
+type JettonTransfer struct {
+	Sender           *Account   `json:"sender,omitempty"`
+	Recipient        *Account   `json:"recipient,omitempty"`
+	SendersWallet    string     `json:"senders_wallet"`
+	RecipientsWallet string     `json:"recipients_wallet"`
+	Amount           string     `json:"amount"` // in jetton units
+	Comment          string     `json:"comment,omitempty"`
+	Jetton           JettonInfo `json:"jetton"`
+}
+
 // JettonSwap represents a DEX swap action
 type JettonSwap struct {
 	Dex             string       `json:"dex"`
@@ -36,6 +59,77 @@ type JettonSwap struct {
 	Router          Account      `json:"router"`
 }
 
+// NftItemTransfer represents an NFT transfer action.
+// Only the item address is included, use GetNftItem for metadata.
+type NftItemTransfer struct {
+	Sender    *Account `json:"sender,omitempty"`
+	Recipient *Account `json:"recipient,omitempty"`
+	Nft       string   `json:"nft"`
+	Comment   string   `json:"comment,omitempty"`
+}
+
+// NftPurchase represents an NFT purchase on a marketplace or auction
+type NftPurchase struct {
+	AuctionType string  `json:"auction_type"` // "getgems", "DNS.ton", "DNS.tg", "NUMBER.tg"
+	Amount      Price   `json:"amount"`
+	Nft         NftItem `json:"nft"`
+	Seller      Account `json:"seller"`
+	Buyer       Account `json:"buyer"`
+}
+
+// Price is an amount of TON or jettons
+type Price struct {
+	Value     string `json:"value"` // in nanoTON or jetton units
+	TokenName string `json:"token_name"`
+	Decimals  int    `json:"decimals,omitempty"`
+}
+
+// NftItem contains NFT item data
+type NftItem struct {
+	Address    string                 `json:"address"`
+	Index      int64                  `json:"index"`
+	Owner      *Account               `json:"owner,omitempty"`
+	Collection *NftCollection         `json:"collection,omitempty"`
+	Verified   bool                   `json:"verified"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Sale       *NftSale               `json:"sale,omitempty"`
+	DNS        string                 `json:"dns,omitempty"`
+}
+
+// NftCollection contains NFT collection metadata
+type NftCollection struct {
+	Address     string `json:"address"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// NftSale describes an active sale of an NFT item
+type NftSale struct {
+	Address string   `json:"address"`
+	Market  Account  `json:"market"`
+	Owner   *Account `json:"owner,omitempty"`
+	Price   Price    `json:"price"`
+}
+
+// Stake represents a staking pool action (DepositStake, WithdrawStake or
+// WithdrawStakeRequest). Amount may be zero for withdraw requests.
+type Stake struct {
+	Amount         int64   `json:"amount,omitempty"` // in nanoTON
+	Staker         Account `json:"staker"`
+	Pool           Account `json:"pool"`
+	Implementation string  `json:"implementation"` // "whales", "tf", "liquidTF"
+}
+
+// SmartContractExec represents a contract call that isn't decoded
+// into a more specific action
+type SmartContractExec struct {
+	Executor    Account `json:"executor"`
+	Contract    Account `json:"contract"`
+	TonAttached int64   `json:"ton_attached"` // in nanoTON
+	Operation   string  `json:"operation"`
+	Payload     string  `json:"payload,omitempty"`
+}
+
 // JettonInfo contains jetton metadata
 type JettonInfo struct {
 	Address  string `json:"address"`
@@ -45,6 +139,17 @@ type JettonInfo struct {
 	Image    string `json:"image,omitempty"`
 }
 
+// JettonResponse is the response from jetton info endpoint
+type JettonResponse struct {
+	Metadata struct {
+		Address  string `json:"address"`
+		Name     string `json:"name"`
+		Symbol   string `json:"symbol"`
+		Decimals string `json:"decimals"`
+		Image    string `json:"image,omitempty"`
+	} `json:"metadata"`
+}
+
 // Account represents an account/wallet
 type Account struct {
 	Address  string `json:"address"`
@@ -62,7 +167,18 @@ type AccountInfo struct {
 
 // EventsResponse is the response from events endpoint
 type EventsResponse struct {
-	Events []Event `json:"events"`
+	Events   []Event `json:"events"`
+	NextFrom int64   `json:"next_from"` // before_lt for the next page, 0 on the last page
+}
+
+// RatesResponse is the response from rates endpoint
+type RatesResponse struct {
+	Rates map[string]TokenRates `json:"rates"`
+}
+
+// TokenRates contains token prices keyed by currency (e.g. "TON", "USD")
+type TokenRates struct {
+	Prices map[string]float64 `json:"prices"`
 }
 
 // WebhookPayload is the payload received from TonAPI webhook
@@ -85,3 +201,14 @@ type Webhook struct {
 type WebhookListResponse struct {
 	Webhooks []Webhook `json:"webhooks"`
 }
+
+// AccountSubscription is an account subscribed to a webhook
+type AccountSubscription struct {
+	AccountID       string `json:"account_id"`
+	LastDeliveredLt int64  `json:"last_delivered_lt,omitempty"`
+}
+
+// AccountSubscriptionsResponse is the response from webhook subscriptions endpoint
+type AccountSubscriptionsResponse struct {
+	Subscriptions []AccountSubscription `json:"account_tx_subscriptions"`
+}
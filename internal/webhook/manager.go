@@ -3,18 +3,25 @@ package webhook
 import (
 	"context"
 	"log/slog"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/suspectuso/ton-tracker/internal/metrics"
 	"github.com/suspectuso/ton-tracker/internal/storage"
 	"github.com/suspectuso/ton-tracker/internal/tonapi"
 )
 
+// subscribeBatchSize bounds the accounts per subscribe/unsubscribe request
+const subscribeBatchSize = 100
+
 // Manager manages TonAPI webhook subscriptions
 type Manager struct {
-	storage    *storage.Storage
+	storage    storage.Store
 	tonAPI     *tonapi.Client
 	endpoint   string
+	secret     string
 	log        *slog.Logger
 
 	mu          sync.Mutex
@@ -22,12 +29,14 @@ type Manager struct {
 	subscribed  map[string]bool
 }
 
-// NewManager creates a new webhook manager
-func NewManager(store *storage.Storage, tonAPI *tonapi.Client, endpoint string, log *slog.Logger) *Manager {
+// NewManager creates a new webhook manager.
+// A non-empty secret is appended to the endpoint path when registering with TonAPI.
+func NewManager(store storage.Store, tonAPI *tonapi.Client, endpoint, secret string, log *slog.Logger) *Manager {
 	return &Manager{
 		storage:    store,
 		tonAPI:     tonAPI,
-		endpoint:   endpoint,
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		secret:     secret,
 		log:        log,
 		subscribed: make(map[string]bool),
 	}
@@ -46,17 +55,28 @@ func (m *Manager) Init(ctx context.Context) error {
 		return err
 	}
 
-	// Find or create webhook
+	// Find our webhook, dropping ones registered without the secret or with a rotated one
+	registered := m.registeredEndpoint()
 	for _, wh := range webhooks {
-		if wh.Endpoint == m.endpoint {
+		switch {
+		case wh.Endpoint == registered:
 			m.webhookID = wh.ID
-			m.log.Info("using existing webhook", "id", wh.ID)
-			return nil
+		case m.secret != "" && (wh.Endpoint == m.endpoint || strings.HasPrefix(wh.Endpoint, m.endpoint+"/")):
+			if err := m.tonAPI.DeleteWebhook(ctx, wh.ID); err != nil {
+				m.log.Warn("delete stale webhook", "id", wh.ID, "error", err)
+			} else {
+				m.log.Info("deleted stale webhook", "id", wh.ID)
+			}
 		}
 	}
 
+	if m.webhookID != 0 {
+		m.log.Info("using existing webhook", "id", m.webhookID)
+		return m.reconcile(ctx)
+	}
+
 	// Create new webhook
-	webhook, err := m.tonAPI.CreateWebhook(ctx, m.endpoint)
+	webhook, err := m.tonAPI.CreateWebhook(ctx, registered)
 	if err != nil {
 		return err
 	}
@@ -64,27 +84,71 @@ func (m *Manager) Init(ctx context.Context) error {
 	m.webhookID = webhook.ID
 	m.log.Info("created new webhook", "id", webhook.ID)
 
+	// A new webhook has no subscriptions, SyncLoop's first sync subscribes every wallet
 	return nil
 }
 
-// SyncLoop periodically syncs subscriptions with wallets in DB
+// reconcile loads the accounts TonAPI already has subscribed and syncs them with the DB,
+// dropping addresses removed while the bot was down
+func (m *Manager) reconcile(ctx context.Context) error {
+	accounts, err := m.tonAPI.ListSubscribedAccounts(ctx, m.webhookID)
+	if err != nil {
+		// Fall back to resubscribing everything on the first sync
+		m.log.Warn("list subscribed accounts", "error", err)
+		return nil
+	}
+
+	m.mu.Lock()
+	for _, addr := range accounts {
+		m.subscribed[tonapi.NormalizeAddress(addr)] = true
+	}
+	m.mu.Unlock()
+
+	m.log.Info("loaded webhook subscriptions", "count", len(accounts))
+	return m.sync(ctx)
+}
+
+// registeredEndpoint returns the URL TonAPI calls, with the secret as the last path segment
+func (m *Manager) registeredEndpoint() string {
+	if m.secret == "" {
+		return m.endpoint
+	}
+	return m.endpoint + "/" + url.PathEscape(m.secret)
+}
+
+// SyncLoop applies wallet changes to subscriptions as they happen
+// and periodically syncs them with wallets in DB as a safety net
 func (m *Manager) SyncLoop(ctx context.Context, interval time.Duration) {
 	if m.endpoint == "" {
 		return
 	}
 
-	// Initial sync after delay
-	time.Sleep(5 * time.Second)
+	// Initial sync, Init only reconciles a webhook that already existed
+	if err := m.sync(ctx); err != nil {
+		m.log.Error("sync subscriptions", "error", err)
+	}
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	m.log.Info("webhook sync loop started", "interval", interval)
 
+	changes := m.storage.WalletChanges()
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case change := <-changes:
+			if change.Resync {
+				m.log.Warn("wallet changes dropped, resyncing subscriptions")
+				if err := m.sync(ctx); err != nil {
+					m.log.Error("sync subscriptions", "error", err)
+				}
+				continue
+			}
+			if err := m.apply(ctx, change); err != nil {
+				m.log.Error("apply wallet change", "error", err, "address", change.AddressRaw)
+			}
 		case <-ticker.C:
 			if err := m.sync(ctx); err != nil {
 				m.log.Error("sync subscriptions", "error", err)
@@ -130,31 +194,99 @@ func (m *Manager) sync(ctx context.Context) error {
 
 	// Subscribe new addresses
 	if len(toAdd) > 0 {
-		if err := m.tonAPI.SubscribeAccounts(ctx, m.webhookID, toAdd); err != nil {
-			m.log.Error("subscribe accounts", "error", err, "count", len(toAdd))
+		done := m.inBatches(ctx, toAdd, m.tonAPI.SubscribeAccounts, func(addr string) {
+			m.subscribed[addr] = true
+		})
+		if done < len(toAdd) {
+			m.log.Error("subscribe accounts", "subscribed", done, "count", len(toAdd))
 		} else {
-			for _, addr := range toAdd {
-				m.subscribed[addr] = true
-			}
-			m.log.Info("subscribed accounts", "count", len(toAdd))
+			m.log.Info("subscribed accounts", "count", done)
 		}
 	}
 
 	// Unsubscribe removed addresses
 	if len(toRemove) > 0 {
-		if err := m.tonAPI.UnsubscribeAccounts(ctx, m.webhookID, toRemove); err != nil {
-			m.log.Error("unsubscribe accounts", "error", err, "count", len(toRemove))
+		done := m.inBatches(ctx, toRemove, m.tonAPI.UnsubscribeAccounts, func(addr string) {
+			delete(m.subscribed, addr)
+		})
+		if done < len(toRemove) {
+			m.log.Error("unsubscribe accounts", "unsubscribed", done, "count", len(toRemove))
 		} else {
-			for _, addr := range toRemove {
-				delete(m.subscribed, addr)
-			}
-			m.log.Info("unsubscribed accounts", "count", len(toRemove))
+			m.log.Info("unsubscribed accounts", "count", done)
 		}
 	}
 
+	metrics.Subscriptions.Set(float64(len(m.subscribed)))
 	return nil
 }
 
+// apply subscribes an added address or unsubscribes a removed one
+// that no other wallet tracks. Failures are retried by the periodic sync.
+func (m *Manager) apply(ctx context.Context, change storage.WalletChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.webhookID == 0 {
+		return nil
+	}
+
+	addr := change.AddressRaw
+	if change.Removed {
+		if !m.subscribed[addr] {
+			return nil
+		}
+
+		// Still tracked by another wallet
+		wallets, err := m.storage.GetWalletsByRaw(addr)
+		if err != nil {
+			return err
+		}
+		if len(wallets) > 0 {
+			return nil
+		}
+
+		if err := m.tonAPI.UnsubscribeAccounts(ctx, m.webhookID, []string{addr}); err != nil {
+			return err
+		}
+		delete(m.subscribed, addr)
+		m.log.Info("unsubscribed account", "address", addr)
+	} else {
+		if m.subscribed[addr] {
+			return nil
+		}
+
+		if err := m.tonAPI.SubscribeAccounts(ctx, m.webhookID, []string{addr}); err != nil {
+			return err
+		}
+		m.subscribed[addr] = true
+		m.log.Info("subscribed account", "address", addr)
+	}
+
+	metrics.Subscriptions.Set(float64(len(m.subscribed)))
+	return nil
+}
+
+// inBatches calls fn for chunks of subscribeBatchSize accounts and applies ok to
+// each account of a successful chunk, returns the number of accounts applied
+func (m *Manager) inBatches(ctx context.Context, accounts []string, fn func(ctx context.Context, webhookID int64, accounts []string) error, ok func(addr string)) int {
+	done := 0
+	for i := 0; i < len(accounts); i += subscribeBatchSize {
+		batch := accounts[i:min(i+subscribeBatchSize, len(accounts))]
+		if err := fn(ctx, m.webhookID, batch); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			m.log.Warn("webhook subscription batch", "error", err, "count", len(batch))
+			continue
+		}
+		for _, addr := range batch {
+			ok(addr)
+		}
+		done += len(batch)
+	}
+	return done
+}
+
 // GetWebhookID returns the current webhook ID
 func (m *Manager) GetWebhookID() int64 {
 	m.mu.Lock()
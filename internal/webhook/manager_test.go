@@ -0,0 +1,308 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/storage"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+const testEndpoint = "https://bot.example/webhook"
+
+// fakeWalletStore serves the wallets the manager reads, other Store methods are not implemented
+type fakeWalletStore struct {
+	storage.Store
+	mu      sync.Mutex
+	wallets []storage.Wallet
+	changes chan storage.WalletChange
+}
+
+func (f *fakeWalletStore) GetAllWallets() ([]storage.Wallet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.wallets, nil
+}
+
+func (f *fakeWalletStore) WalletChanges() <-chan storage.WalletChange {
+	return f.changes
+}
+
+func (f *fakeWalletStore) setWallets(wallets []storage.Wallet) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.wallets = wallets
+}
+
+func (f *fakeWalletStore) GetWalletsByRaw(addressRaw string) ([]storage.Wallet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var wallets []storage.Wallet
+	for _, w := range f.wallets {
+		if w.AddressRaw == addressRaw {
+			wallets = append(wallets, w)
+		}
+	}
+	return wallets, nil
+}
+
+// fakeWebhooks is a TonAPI webhook API with one webhook
+type fakeWebhooks struct {
+	mu          sync.Mutex
+	webhookID   int64
+	subscribed  map[string]bool
+	listOffsets []int
+	subscribes  []int // batch sizes
+	unsubscribe []int
+}
+
+func newFakeWebhooks(t *testing.T, webhookID int64, subscribed []string) (*fakeWebhooks, *tonapi.Client) {
+	t.Helper()
+
+	f := &fakeWebhooks{webhookID: webhookID, subscribed: make(map[string]bool)}
+	for _, addr := range subscribed {
+		f.subscribed[addr] = true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /webhooks", func(w http.ResponseWriter, r *http.Request) {
+		var resp tonapi.WebhookListResponse
+		if f.webhookID != 0 {
+			resp.Webhooks = append(resp.Webhooks, tonapi.Webhook{ID: f.webhookID, Endpoint: testEndpoint})
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("POST /webhooks", func(w http.ResponseWriter, r *http.Request) {
+		f.webhookID = 7
+		json.NewEncoder(w).Encode(tonapi.Webhook{ID: f.webhookID, Endpoint: testEndpoint})
+	})
+	mux.HandleFunc("GET /webhooks/{id}/account-tx/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.listOffsets = append(f.listOffsets, offset)
+
+		accounts := f.accounts()
+		var resp tonapi.AccountSubscriptionsResponse
+		for _, addr := range accounts[min(offset, len(accounts)):min(offset+limit, len(accounts))] {
+			resp.Subscriptions = append(resp.Subscriptions, tonapi.AccountSubscription{AccountID: addr})
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("POST /webhooks/{id}/account-tx/{op}", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Accounts []string `json:"accounts"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch r.PathValue("op") {
+		case "subscribe":
+			f.subscribes = append(f.subscribes, len(body.Accounts))
+			for _, addr := range body.Accounts {
+				f.subscribed[addr] = true
+			}
+		case "unsubscribe":
+			f.unsubscribe = append(f.unsubscribe, len(body.Accounts))
+			for _, addr := range body.Accounts {
+				delete(f.subscribed, addr)
+			}
+		}
+		w.Write([]byte("{}"))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return f, tonapi.NewClient(srv.URL, "", 1000)
+}
+
+// accounts returns the subscribed accounts in a stable order, f.mu must be held
+func (f *fakeWebhooks) accounts() []string {
+	accounts := make([]string, 0, len(f.subscribed))
+	for addr := range f.subscribed {
+		accounts = append(accounts, addr)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+func testAccount(i int) string {
+	return fmt.Sprintf("0:%064x", i)
+}
+
+func testWallets(from, to int) []storage.Wallet {
+	var wallets []storage.Wallet
+	for i := from; i < to; i++ {
+		wallets = append(wallets, storage.Wallet{ID: int64(i + 1), AddressRaw: testAccount(i)})
+	}
+	return wallets
+}
+
+func TestManagerInitReconciles(t *testing.T) {
+	// TonAPI has 1000 kept and 50 stale accounts, the DB 250 more it doesn't have
+	var subscribed []string
+	for i := 0; i < 1050; i++ {
+		subscribed = append(subscribed, testAccount(i))
+	}
+	fake, client := newFakeWebhooks(t, 3, subscribed)
+	store := &fakeWalletStore{wallets: append(testWallets(0, 1000), testWallets(1050, 1300)...)}
+
+	m := NewManager(store, client, testEndpoint, "", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := m.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.GetWebhookID() != 3 {
+		t.Errorf("webhook id = %d, want the existing 3", m.GetWebhookID())
+	}
+	if fmt.Sprint(fake.listOffsets) != "[0 1000]" {
+		t.Errorf("listed offsets %v, want [0 1000]", fake.listOffsets)
+	}
+	if fmt.Sprint(fake.subscribes) != "[100 100 50]" {
+		t.Errorf("subscribe batches %v, want [100 100 50]", fake.subscribes)
+	}
+	if fmt.Sprint(fake.unsubscribe) != "[50]" {
+		t.Errorf("unsubscribe batches %v, want [50]", fake.unsubscribe)
+	}
+
+	// Stale accounts are gone, missing ones added
+	if len(fake.subscribed) != len(store.wallets) {
+		t.Errorf("%d accounts subscribed, want %d", len(fake.subscribed), len(store.wallets))
+	}
+	for _, w := range store.wallets {
+		if !fake.subscribed[w.AddressRaw] {
+			t.Fatalf("wallet account %s not subscribed", w.AddressRaw)
+		}
+	}
+	if len(m.subscribed) != len(store.wallets) {
+		t.Errorf("manager tracks %d subscriptions, want %d", len(m.subscribed), len(store.wallets))
+	}
+}
+
+func TestManagerInitCreatesWebhook(t *testing.T) {
+	fake, client := newFakeWebhooks(t, 0, nil)
+	store := &fakeWalletStore{wallets: testWallets(0, 150)}
+
+	m := NewManager(store, client, testEndpoint, "", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := m.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetWebhookID() != 7 {
+		t.Fatalf("webhook id = %d, want the created 7", m.GetWebhookID())
+	}
+
+	// Nothing to reconcile on a new webhook, the first sync subscribes everything
+	if len(fake.listOffsets) != 0 || len(fake.subscribed) != 0 {
+		t.Errorf("new webhook listed %v and subscribed %d accounts in Init", fake.listOffsets, len(fake.subscribed))
+	}
+	if err := m.sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(fake.subscribes) != "[100 50]" {
+		t.Errorf("subscribe batches %v, want [100 50]", fake.subscribes)
+	}
+}
+
+func TestManagerApply(t *testing.T) {
+	shared := testAccount(1)
+	fake, client := newFakeWebhooks(t, 3, nil)
+	store := &fakeWalletStore{}
+
+	m := NewManager(store, client, testEndpoint, "", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := m.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name       string
+		wallets    []storage.Wallet // DB after the change
+		change     storage.WalletChange
+		subscribed bool
+	}{
+		{
+			name:       "added address is subscribed",
+			wallets:    []storage.Wallet{{ID: 1, AddressRaw: shared}, {ID: 2, AddressRaw: shared}},
+			change:     storage.WalletChange{AddressRaw: shared},
+			subscribed: true,
+		},
+		{
+			name:       "address still tracked by another wallet stays",
+			wallets:    []storage.Wallet{{ID: 2, AddressRaw: shared}},
+			change:     storage.WalletChange{AddressRaw: shared, Removed: true},
+			subscribed: true,
+		},
+		{
+			name:       "last wallet removed unsubscribes",
+			wallets:    nil,
+			change:     storage.WalletChange{AddressRaw: shared, Removed: true},
+			subscribed: false,
+		},
+		{
+			name:       "added again",
+			wallets:    []storage.Wallet{{ID: 3, AddressRaw: shared}},
+			change:     storage.WalletChange{AddressRaw: shared},
+			subscribed: true,
+		},
+	}
+
+	for _, step := range steps {
+		store.setWallets(step.wallets)
+		if err := m.apply(context.Background(), step.change); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if fake.subscribed[shared] != step.subscribed {
+			t.Errorf("%s: subscribed at TonAPI = %v, want %v", step.name, fake.subscribed[shared], step.subscribed)
+		}
+	}
+	if fmt.Sprint(fake.subscribes, fake.unsubscribe) != "[1 1] [1]" {
+		t.Errorf("subscribe and unsubscribe batches %v %v, want [1 1] [1]", fake.subscribes, fake.unsubscribe)
+	}
+}
+
+func TestManagerResync(t *testing.T) {
+	fake, client := newFakeWebhooks(t, 3, nil)
+	store := &fakeWalletStore{wallets: testWallets(0, 3), changes: make(chan storage.WalletChange)}
+
+	m := NewManager(store, client, testEndpoint, "", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := m.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.SyncLoop(ctx, time.Hour)
+		close(done)
+	}()
+
+	// Wallets changed without their changes, as if they were dropped.
+	// The channel is unbuffered, so the second send waits for the first resync.
+	store.setWallets(testWallets(2, 5))
+	store.changes <- storage.WalletChange{Resync: true}
+	store.changes <- storage.WalletChange{Resync: true}
+	cancel()
+	<-done
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	want := []string{testAccount(2), testAccount(3), testAccount(4)}
+	if got := fake.accounts(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("subscribed after resync %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,208 @@
+package webhook
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/storage"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+const (
+	pollTick          = time.Second
+	pollRefreshEvery  = 5 * time.Minute // address list reload besides wallet changes
+	pollMaxEventsOnce = 500             // oldest events queued per address and poll, newer ones wait for the next poll
+)
+
+// Poller is the tracker mode for deployments without a public webhook endpoint.
+// It polls tracked addresses for new events and feeds them into the webhook
+// server's queue, so they go through the same deduplication and handlers.
+// Active addresses are polled every minInterval, quiet ones back off to maxInterval.
+type Poller struct {
+	storage storage.Store
+	events  tonapi.EventSource
+	server  *Server
+	log     *slog.Logger
+
+	minInterval time.Duration
+	maxInterval time.Duration
+
+	accounts    map[string]*polledAccount
+	lastRefresh time.Time
+}
+
+type polledAccount struct {
+	lastLt   int64
+	interval time.Duration
+	nextPoll time.Time
+}
+
+// NewPoller creates a new poller reading events from the given source,
+// a tonapi.Client or a FallbackSource with liteservers
+func NewPoller(cfg *config.Config, store storage.Store, events tonapi.EventSource, server *Server, log *slog.Logger) *Poller {
+	minInterval := cfg.PollMinInterval
+	if minInterval <= 0 {
+		minInterval = 10 * time.Second
+	}
+	maxInterval := cfg.PollMaxInterval
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+
+	return &Poller{
+		storage:     store,
+		events:      events,
+		server:      server,
+		log:         log,
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		accounts:    make(map[string]*polledAccount),
+	}
+}
+
+// Start polls until ctx is cancelled
+func (p *Poller) Start(ctx context.Context) {
+	p.log.Info("polling tracker started",
+		"min_interval", p.minInterval,
+		"max_interval", p.maxInterval,
+	)
+
+	ticker := time.NewTicker(pollTick)
+	defer ticker.Stop()
+
+	changes := p.storage.WalletChanges()
+	for {
+		if time.Since(p.lastRefresh) >= pollRefreshEvery {
+			if err := p.refresh(); err != nil {
+				p.log.Error("refresh polled accounts", "error", err)
+			}
+		}
+
+		now := time.Now()
+		for addr, acc := range p.accounts {
+			if ctx.Err() != nil {
+				return
+			}
+			if now.Before(acc.nextPoll) {
+				continue
+			}
+			p.poll(ctx, addr, acc)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			// Pick up added and removed wallets right away
+			p.lastRefresh = time.Time{}
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh syncs the polled addresses with wallets in storage
+func (p *Poller) refresh() error {
+	wallets, err := p.storage.GetAllWallets()
+	if err != nil {
+		return err
+	}
+
+	cursors, err := p.storage.ListAccountCursors()
+	if err != nil {
+		return err
+	}
+
+	needed := make(map[string]bool)
+	for _, w := range wallets {
+		needed[w.AddressRaw] = true
+		if _, ok := p.accounts[w.AddressRaw]; !ok {
+			p.accounts[w.AddressRaw] = &polledAccount{
+				lastLt:   cursors[w.AddressRaw],
+				interval: p.minInterval,
+			}
+		}
+	}
+
+	for addr := range p.accounts {
+		if !needed[addr] {
+			delete(p.accounts, addr)
+		}
+	}
+
+	p.lastRefresh = time.Now()
+	return nil
+}
+
+func (p *Poller) poll(ctx context.Context, addr string, acc *polledAccount) {
+	found, err := p.fetch(ctx, addr, acc, func(payload tonapi.WebhookPayload) bool {
+		if !p.server.Enqueue(payload) {
+			p.log.Warn("webhook queue full, polling paused", "address", addr)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		p.log.Warn("poll account", "address", addr, "error", err)
+	}
+
+	// Adapt the interval to the address activity
+	if found > 0 {
+		acc.interval = p.minInterval
+	} else {
+		acc.interval *= 2
+		if acc.interval > p.maxInterval {
+			acc.interval = p.maxInterval
+		}
+	}
+	acc.nextPoll = time.Now().Add(acc.interval)
+}
+
+// fetch queues up to pollMaxEventsOnce events newer than the account cursor
+// with enqueue, returns how many were queued. It stops at the first event
+// enqueue refuses.
+func (p *Poller) fetch(ctx context.Context, addr string, acc *polledAccount, enqueue func(tonapi.WebhookPayload) bool) (int, error) {
+	// Never seen: start from the latest event instead of the whole history
+	if acc.lastLt == 0 {
+		events, err := p.events.GetEvents(ctx, addr, 1)
+		if err != nil || len(events) == 0 {
+			return 0, err
+		}
+		acc.lastLt = events[0].Lt
+		return 0, p.storage.AdvanceAccountCursor(addr, acc.lastLt)
+	}
+
+	// Events come newest first, so walk back to the cursor and keep the
+	// oldest ones: queueing the newest would move the cursor past the rest
+	var events []tonapi.Event
+	err := p.events.IterateEvents(ctx, addr, acc.lastLt, 0, func(ev *tonapi.Event) bool {
+		events = append(events, *ev)
+		if len(events) > pollMaxEventsOnce {
+			events = events[1:]
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Oldest first; stop at events still in progress, they are picked up once complete
+	queued := 0
+	for i := len(events) - 1; i >= 0; i-- {
+		ev := events[i]
+		if ev.InProgress {
+			break
+		}
+
+		payload := tonapi.WebhookPayload{AccountID: addr, Lt: ev.Lt, Event: &ev}
+		if !enqueue(payload) {
+			break
+		}
+
+		acc.lastLt = ev.Lt
+		queued++
+	}
+
+	return queued, nil
+}
@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+// fakeEvents serves events with lts from 1 to newest, newest first
+type fakeEvents struct {
+	newest     int64
+	inProgress int64 // lt of an event still in progress, 0 for none
+}
+
+func (f *fakeEvents) GetEvents(ctx context.Context, address string, limit int) ([]tonapi.Event, error) {
+	var events []tonapi.Event
+	err := f.IterateEvents(ctx, address, 0, 0, func(ev *tonapi.Event) bool {
+		events = append(events, *ev)
+		return len(events) < limit
+	})
+	return events, err
+}
+
+func (f *fakeEvents) IterateEvents(ctx context.Context, address string, sinceLt, untilLt int64, fn func(ev *tonapi.Event) bool) error {
+	for lt := f.newest; lt > sinceLt; lt-- {
+		if untilLt > 0 && lt >= untilLt {
+			continue
+		}
+		if !fn(&tonapi.Event{EventID: "ev", Lt: lt, InProgress: lt == f.inProgress}) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func TestPollerFetch(t *testing.T) {
+	tests := []struct {
+		name       string
+		cursor     int64
+		events     fakeEvents
+		wantQueued []int // events queued by each fetch
+		wantLastLt int64
+	}{
+		{
+			name:       "few new events",
+			cursor:     100,
+			events:     fakeEvents{newest: 110},
+			wantQueued: []int{10, 0},
+			wantLastLt: 110,
+		},
+		{
+			name:       "more than one poll takes, oldest first",
+			cursor:     100,
+			events:     fakeEvents{newest: 100 + 2*pollMaxEventsOnce + 50},
+			wantQueued: []int{pollMaxEventsOnce, pollMaxEventsOnce, 50, 0},
+			wantLastLt: 100 + 2*pollMaxEventsOnce + 50,
+		},
+		{
+			name:       "stops before an event in progress",
+			cursor:     100,
+			events:     fakeEvents{newest: 110, inProgress: 105},
+			wantQueued: []int{4, 0},
+			wantLastLt: 104,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				queued []int64
+			)
+			server := &Server{pool: newPool(1, 10*pollMaxEventsOnce, func(ctx context.Context, payload tonapi.WebhookPayload) {
+				mu.Lock()
+				queued = append(queued, payload.Lt)
+				mu.Unlock()
+			})}
+
+			cfg := &config.Config{PollMinInterval: time.Second, PollMaxInterval: time.Minute}
+			p := NewPoller(cfg, nil, &tt.events, server, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			acc := &polledAccount{lastLt: tt.cursor}
+
+			for i, want := range tt.wantQueued {
+				got, err := p.fetch(context.Background(), "0:abc", acc, server.Enqueue)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("fetch %d queued %d events, want %d", i, got, want)
+				}
+			}
+			server.pool.drain(5 * time.Second)
+
+			if acc.lastLt != tt.wantLastLt {
+				t.Errorf("lastLt = %d, want %d", acc.lastLt, tt.wantLastLt)
+			}
+
+			// Every event above the cursor is queued exactly once, in order
+			for i, lt := range queued {
+				if lt != tt.cursor+int64(i)+1 {
+					t.Fatalf("queued event %d has lt %d, want %d", i, lt, tt.cursor+int64(i)+1)
+				}
+			}
+			if int64(len(queued)) != tt.wantLastLt-tt.cursor {
+				t.Errorf("queued %d events, want %d", len(queued), tt.wantLastLt-tt.cursor)
+			}
+		})
+	}
+}
+
+func TestStreamCatchUpWaitsForQueue(t *testing.T) {
+	const cursor = 100
+	events := &fakeEvents{newest: cursor + 2*pollMaxEventsOnce + 10}
+
+	var (
+		mu     sync.Mutex
+		queued []int64
+	)
+	gate := make(chan struct{})
+	time.AfterFunc(200*time.Millisecond, func() { close(gate) })
+	server := &Server{pool: newPool(1, pollMaxEventsOnce/2, func(ctx context.Context, payload tonapi.WebhookPayload) {
+		<-gate // keep the queue full for a while
+		mu.Lock()
+		queued = append(queued, payload.Lt)
+		mu.Unlock()
+	})}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{PollMinInterval: time.Second, PollMaxInterval: time.Minute}
+	tr := &StreamTracker{server: server, poller: NewPoller(cfg, nil, events, server, log), log: log}
+
+	tr.catchUpFrom(context.Background(), map[string]int64{"0:abc": cursor})
+	server.pool.drain(5 * time.Second)
+
+	if int64(len(queued)) != events.newest-cursor {
+		t.Fatalf("queued %d events, want %d", len(queued), events.newest-cursor)
+	}
+	for i, lt := range queued {
+		if lt != cursor+int64(i)+1 {
+			t.Fatalf("queued event %d has lt %d, want %d", i, lt, cursor+int64(i)+1)
+		}
+	}
+}
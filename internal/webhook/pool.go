@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+// pool processes webhook payloads on a fixed number of workers.
+// Payloads of one account always go to the same worker, so they are
+// handled one at a time and in logical-time order.
+type pool struct {
+	handle func(ctx context.Context, payload tonapi.WebhookPayload)
+
+	mu     sync.RWMutex
+	closed bool
+	shards []chan tonapi.WebhookPayload
+
+	ctx    context.Context // cancelled when draining times out
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newPool(workers, queueSize int, handle func(ctx context.Context, payload tonapi.WebhookPayload)) *pool {
+	if workers < 1 {
+		workers = 1
+	}
+	perShard := queueSize / workers
+	if perShard < 1 {
+		perShard = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &pool{
+		handle: handle,
+		shards: make([]chan tonapi.WebhookPayload, workers),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	for i := range p.shards {
+		p.shards[i] = make(chan tonapi.WebhookPayload, perShard)
+		p.wg.Add(1)
+		go p.work(p.shards[i])
+	}
+
+	return p
+}
+
+// submit queues a payload, returns false if its worker's queue is full or the pool is closed
+func (p *pool) submit(payload tonapi.WebhookPayload) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(payload.AccountID))
+	shard := p.shards[h.Sum32()%uint32(len(p.shards))]
+
+	select {
+	case shard <- payload:
+		return true
+	default:
+		return false
+	}
+}
+
+// depth returns the number of queued payloads
+func (p *pool) depth() int {
+	n := 0
+	for _, shard := range p.shards {
+		n += len(shard)
+	}
+	return n
+}
+
+// drain stops accepting payloads and waits for queued ones to be processed.
+// After timeout the processing context is cancelled and drain waits for workers to exit.
+func (p *pool) drain(timeout time.Duration) {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, shard := range p.shards {
+			close(shard)
+		}
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		p.cancel()
+		<-done
+	}
+	p.cancel()
+}
+
+func (p *pool) work(shard chan tonapi.WebhookPayload) {
+	defer p.wg.Done()
+
+	for payload := range shard {
+		// Take whatever else is already queued, so events that arrived
+		// out of order can be handled by logical time
+		batch := []tonapi.WebhookPayload{payload}
+	collect:
+		for {
+			select {
+			case next, ok := <-shard:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, next)
+			default:
+				break collect
+			}
+		}
+
+		sort.SliceStable(batch, func(i, j int) bool { return batch[i].Lt < batch[j].Lt })
+		for _, pl := range batch {
+			p.handle(p.ctx, pl)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+func TestPoolPerAccountOrdering(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		handled = make(map[string][]int64)
+		active  = make(map[string]int)
+	)
+
+	p := newPool(4, 1000, func(ctx context.Context, payload tonapi.WebhookPayload) {
+		mu.Lock()
+		active[payload.AccountID]++
+		if active[payload.AccountID] > 1 {
+			t.Errorf("account %s handled concurrently", payload.AccountID)
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		active[payload.AccountID]--
+		handled[payload.AccountID] = append(handled[payload.AccountID], payload.Lt)
+		mu.Unlock()
+	})
+
+	const accounts, events = 8, 20
+	for lt := int64(1); lt <= events; lt++ {
+		for a := 0; a < accounts; a++ {
+			if !p.submit(tonapi.WebhookPayload{AccountID: "0:" + strconv.Itoa(a), Lt: lt}) {
+				t.Fatalf("submit rejected lt %d of account %d", lt, a)
+			}
+		}
+	}
+	p.drain(5 * time.Second)
+
+	for a := 0; a < accounts; a++ {
+		got := handled["0:"+strconv.Itoa(a)]
+		if len(got) != events || !slices.IsSorted(got) {
+			t.Errorf("account %d handled %v, want lts 1..%d in order", a, got, events)
+		}
+	}
+}
+
+func TestPoolOrdersQueuedByLt(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var got []int64
+
+	p := newPool(1, 10, func(ctx context.Context, payload tonapi.WebhookPayload) {
+		if payload.Lt == 0 {
+			close(started)
+			<-release
+			return
+		}
+		got = append(got, payload.Lt)
+	})
+
+	// Block the worker, so the rest arrive out of order in one batch
+	p.submit(tonapi.WebhookPayload{AccountID: "0:a"})
+	<-started
+	for _, lt := range []int64{30, 10, 20} {
+		p.submit(tonapi.WebhookPayload{AccountID: "0:a", Lt: lt})
+	}
+	close(release)
+	p.drain(5 * time.Second)
+
+	if want := []int64{10, 20, 30}; !slices.Equal(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+}
+
+func TestPoolSubmitRejects(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	p := newPool(1, 2, func(ctx context.Context, payload tonapi.WebhookPayload) {
+		once.Do(func() { close(started) })
+		<-release
+	})
+
+	// One payload in the worker and two queued fill the pool
+	p.submit(tonapi.WebhookPayload{AccountID: "0:a"})
+	<-started
+	accepted := 0
+	for i := 1; i < 5; i++ {
+		if p.submit(tonapi.WebhookPayload{AccountID: "0:a", Lt: int64(i)}) {
+			accepted++
+		}
+	}
+	if accepted != 2 {
+		t.Errorf("accepted %d queued payloads, want 2", accepted)
+	}
+
+	close(release)
+	p.drain(5 * time.Second)
+
+	if p.submit(tonapi.WebhookPayload{AccountID: "0:a"}) {
+		t.Error("submit accepted a payload after drain")
+	}
+}
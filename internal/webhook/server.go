@@ -2,13 +2,17 @@ package webhook
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"sync"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/metrics"
 	"github.com/suspectuso/ton-tracker/internal/storage"
 	"github.com/suspectuso/ton-tracker/internal/tonapi"
 )
@@ -18,31 +22,57 @@ type EventHandler func(ctx context.Context, wallet *storage.Wallet, event *tonap
 
 // Server handles incoming webhooks from TonAPI
 type Server struct {
-	storage  *storage.Storage
+	storage  storage.Store
 	tonAPI   *tonapi.Client
 	handler  EventHandler
+	secret   string
+	webhooks bool // serve /webhook, only in webhook tracker mode
 	log      *slog.Logger
 
-	server *http.Server
+	server   *http.Server
+	pool     *pool
+	drained  chan struct{}
+	rejected atomic.Int64
 }
 
-// NewServer creates a new webhook server
-func NewServer(store *storage.Storage, tonAPI *tonapi.Client, handler EventHandler, log *slog.Logger) *Server {
-	return &Server{
-		storage: store,
-		tonAPI:  tonAPI,
-		handler: handler,
-		log:     log,
+// drainTimeout bounds how long shutdown waits for queued webhooks
+const drainTimeout = 20 * time.Second
+
+// NewServer creates a new webhook server.
+// Calls must carry WebhookSecret as /webhook/<secret> or in the X-Webhook-Secret header.
+func NewServer(cfg *config.Config, store storage.Store, tonAPI *tonapi.Client, handler EventHandler, log *slog.Logger) *Server {
+	s := &Server{
+		storage:  store,
+		tonAPI:   tonAPI,
+		handler:  handler,
+		secret:   cfg.WebhookSecret,
+		webhooks: cfg.TrackerMode == "webhook",
+		log:      log,
+		drained:  make(chan struct{}),
 	}
+	s.pool = newPool(cfg.WebhookWorkers, cfg.WebhookQueueSize, s.processTransaction)
+	return s
+}
+
+// Enqueue queues an event for processing as if it came from TonAPI,
+// returns false if the queue is full
+func (s *Server) Enqueue(payload tonapi.WebhookPayload) bool {
+	return s.pool.submit(payload)
+}
+
+// QueueDepth returns the number of webhooks waiting for a worker
+func (s *Server) QueueDepth() int {
+	return s.pool.depth()
+}
+
+// Rejected returns the number of webhook calls rejected for a missing or wrong secret
+func (s *Server) Rejected() int64 {
+	return s.rejected.Load()
 }
 
 // Start starts the webhook server
 func (s *Server) Start(ctx context.Context, port int) error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/webhook", s.handleWebhook)
-	mux.HandleFunc("/webhook/", s.handleWebhook)
-	mux.HandleFunc("/health", s.handleHealth)
-	mux.HandleFunc("/", s.handleHealth)
+	mux := s.routes()
 
 	s.server = &http.Server{
 		Addr:         fmt.Sprintf(":%d", port),
@@ -58,9 +88,32 @@ func (s *Server) Start(ctx context.Context, port int) error {
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		s.server.Shutdown(shutdownCtx)
+
+		s.log.Info("draining webhook queue", "queued", s.pool.depth())
+		s.pool.drain(drainTimeout)
+		close(s.drained)
 	}()
 
-	return s.server.ListenAndServe()
+	err := s.server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		// Return once queued webhooks are processed
+		<-s.drained
+	}
+	return err
+}
+
+// routes returns the server's handlers. Webhook calls are accepted only in
+// webhook mode, other modes just feed the queue and expose health and metrics.
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	if s.webhooks {
+		mux.HandleFunc("/webhook", s.handleWebhook)
+		mux.HandleFunc("/webhook/", s.handleWebhook)
+	}
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/{$}", s.handleHealth)
+	return mux
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -74,9 +127,24 @@ func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	metrics.WebhooksReceived.Inc()
+
+	if !s.authorized(r) {
+		metrics.WebhooksRejected.WithLabelValues("unauthorized").Inc()
+		total := s.rejected.Add(1)
+		s.log.Warn("rejected webhook call",
+			"remote_addr", r.RemoteAddr,
+			"real_ip", r.Header.Get("X-Real-IP"),
+			"rejected_total", total,
+		)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
 	var payload tonapi.WebhookPayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		s.log.Warn("invalid webhook payload", "error", err)
+		metrics.WebhooksRejected.WithLabelValues("invalid").Inc()
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -87,21 +155,27 @@ func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Account transaction event
-	if payload.AccountID == "" {
-		s.log.Warn("missing account_id in webhook")
-		w.WriteHeader(http.StatusOK)
+	// Account transaction event, reject it before a worker sees a bad account
+	if !tonapi.ValidAddress(payload.AccountID) {
+		s.log.Warn("invalid account_id in webhook", "account", truncate(payload.AccountID, 10))
+		metrics.WebhooksRejected.WithLabelValues("invalid").Inc()
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	s.log.Debug("webhook received",
-		"account", payload.AccountID[:10]+"...",
+		"account", truncate(payload.AccountID, 10),
 		"tx_hash", truncate(payload.TxHash, 10),
 		"has_event", payload.Event != nil,
 	)
 
-	// Process asynchronously
-	go s.processTransaction(context.Background(), payload)
+	// Process asynchronously, ask TonAPI to retry later when overloaded
+	if !s.pool.submit(payload) {
+		s.log.Warn("webhook queue full", "account", truncate(payload.AccountID, 10))
+		metrics.WebhooksRejected.WithLabelValues("queue_full").Inc()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -115,7 +189,7 @@ func (s *Server) processTransaction(ctx context.Context, payload tonapi.WebhookP
 	}
 
 	if len(wallets) == 0 {
-		s.log.Debug("no wallets found for account", "account", payload.AccountID[:10]+"...")
+		s.log.Debug("no wallets found for account", "account", truncate(payload.AccountID, 10))
 		return
 	}
 
@@ -145,10 +219,10 @@ func (s *Server) processTransaction(ctx context.Context, payload tonapi.WebhookP
 		"wallets", len(wallets),
 	)
 
-	// Process for each wallet in parallel
-	var wg sync.WaitGroup
+	// Process for each wallet
+	processed := false
 	for _, w := range wallets {
-		wallet := w // capture
+		wallet := w
 
 		// Check if already processed
 		isNew, err := s.storage.MarkEventProcessed(wallet.ID, event.EventID)
@@ -164,13 +238,41 @@ func (s *Server) processTransaction(ctx context.Context, payload tonapi.WebhookP
 			continue
 		}
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			s.handler(ctx, &wallet, event)
-		}()
+		s.handler(ctx, &wallet, event)
+		processed = true
+	}
+
+	if processed {
+		for _, action := range event.Actions {
+			metrics.EventActions.WithLabelValues(action.Type).Inc()
+		}
+	}
+
+	// Remember how far the address is processed, for backfill after a restart
+	lt := event.Lt
+	if lt == 0 {
+		lt = payload.Lt
+	}
+	if lt > 0 {
+		if err := s.storage.AdvanceAccountCursor(payload.AccountID, lt); err != nil {
+			s.log.Error("advance account cursor", "error", err)
+		}
 	}
-	wg.Wait()
+}
+
+// authorized checks the shared secret in constant time.
+// Without a configured secret every call is rejected.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.secret == "" {
+		return false
+	}
+
+	token := r.Header.Get("X-Webhook-Secret")
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/webhook/"); ok && rest != "" {
+		token = rest
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.secret)) == 1
 }
 
 func truncate(s string, n int) string {
@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+func TestServerAuthorized(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		path   string
+		header string
+		want   bool
+	}{
+		{name: "secret in path", secret: "s3cret", path: "/webhook/s3cret", want: true},
+		{name: "secret in header", secret: "s3cret", path: "/webhook", header: "s3cret", want: true},
+		{name: "wrong secret in path", secret: "s3cret", path: "/webhook/guess", header: "s3cret", want: false},
+		{name: "missing secret", secret: "s3cret", path: "/webhook", want: false},
+		{name: "empty path segment", secret: "s3cret", path: "/webhook/", want: false},
+		{name: "no secret configured", secret: "", path: "/webhook/", want: false},
+		{name: "no secret configured with header", secret: "", path: "/webhook", header: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{secret: tt.secret}
+			r := httptest.NewRequest("POST", tt.path, nil)
+			if tt.header != "" {
+				r.Header.Set("X-Webhook-Secret", tt.header)
+			}
+			if got := s.authorized(r); got != tt.want {
+				t.Errorf("authorized = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerRoutes(t *testing.T) {
+	tests := []struct {
+		name     string
+		webhooks bool
+		method   string
+		path     string
+		want     int
+	}{
+		{name: "health", method: "GET", path: "/health", want: http.StatusOK},
+		{name: "root health", method: "GET", path: "/", want: http.StatusOK},
+		{name: "unknown path", method: "GET", path: "/admin", want: http.StatusNotFound},
+		{name: "metrics", method: "GET", path: "/metrics", want: http.StatusOK},
+		{name: "webhook outside webhook mode", method: "POST", path: "/webhook/s3cret", want: http.StatusNotFound},
+		{name: "webhook in webhook mode", webhooks: true, method: "POST", path: "/webhook/wrong", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{secret: "s3cret", webhooks: tt.webhooks, log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			w := httptest.NewRecorder()
+			s.routes().ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleWebhookAccount(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    int
+		handled bool
+	}{
+		{name: "raw account", body: `{"account_id":"0:` + strings.Repeat("ab", 32) + `","tx_hash":"abc"}`, want: http.StatusOK, handled: true},
+		{name: "missing account", body: `{"tx_hash":"abc"}`, want: http.StatusBadRequest},
+		{name: "short account", body: `{"account_id":"0:ab","tx_hash":"abc"}`, want: http.StatusBadRequest},
+		{name: "garbage account", body: `{"account_id":"not an address","tx_hash":"abc"}`, want: http.StatusBadRequest},
+		{name: "mempool without account", body: `{"event_type":"mempool_msg"}`, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := make(chan tonapi.WebhookPayload, 1)
+			s := &Server{secret: "s3cret", log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			s.pool = newPool(1, 1, func(_ context.Context, payload tonapi.WebhookPayload) { handled <- payload })
+
+			w := httptest.NewRecorder()
+			s.handleWebhook(w, httptest.NewRequest("POST", "/webhook/s3cret", strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+
+			s.pool.drain(time.Second)
+			if got := len(handled) == 1; got != tt.handled {
+				t.Errorf("handled = %v, want %v", got, tt.handled)
+			}
+		})
+	}
+}
+
+// scrapeMetric returns the value of a sample on /metrics, 0 if it isn't exported yet
+func scrapeMetric(t *testing.T, s *Server, sample string) float64 {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, sample+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("parse %s: %v", line, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestWebhookMetrics(t *testing.T) {
+	s := &Server{secret: "s3cret", webhooks: true, log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	s.pool = newPool(1, 1, func(context.Context, tonapi.WebhookPayload) {})
+	defer s.pool.drain(time.Second)
+
+	requests := []struct {
+		path string
+		body string
+	}{
+		{path: "/webhook/wrong", body: "{}"},
+		{path: "/webhook/s3cret", body: "not json"},
+		{path: "/webhook/s3cret", body: `{"account_id":"0:ab"}`},
+	}
+	samples := []struct {
+		name string
+		want float64 // increase after the requests
+	}{
+		{name: "ton_tracker_webhooks_received_total", want: 3},
+		{name: `ton_tracker_webhooks_rejected_total{reason="unauthorized"}`, want: 1},
+		{name: `ton_tracker_webhooks_rejected_total{reason="invalid"}`, want: 2},
+	}
+
+	before := make([]float64, len(samples))
+	for i, sample := range samples {
+		before[i] = scrapeMetric(t, s, sample.name)
+	}
+	for _, r := range requests {
+		s.routes().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", r.path, strings.NewReader(r.body)))
+	}
+	for i, sample := range samples {
+		if got := scrapeMetric(t, s, sample.name) - before[i]; got != sample.want {
+			t.Errorf("%s increased by %v, want %v", sample.name, got, sample.want)
+		}
+	}
+}
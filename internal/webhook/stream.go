@@ -0,0 +1,140 @@
+package webhook
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/suspectuso/ton-tracker/internal/config"
+	"github.com/suspectuso/ton-tracker/internal/metrics"
+	"github.com/suspectuso/ton-tracker/internal/storage"
+	"github.com/suspectuso/ton-tracker/internal/tonapi"
+)
+
+// StreamTracker is the tracker mode that receives transactions over TonAPI SSE
+// instead of webhooks, so no public endpoint is needed. Transactions go into the
+// webhook server's queue, gaps after a reconnect are caught up by polling.
+type StreamTracker struct {
+	storage    storage.Store
+	subscriber *tonapi.Subscriber
+	server     *Server
+	poller     *Poller
+	log        *slog.Logger
+}
+
+// NewStreamTracker creates a new SSE stream tracker.
+// Catch-up reads history from events, the stream itself always comes from TonAPI.
+func NewStreamTracker(cfg *config.Config, store storage.Store, tonAPI *tonapi.Client, events tonapi.EventSource, server *Server, log *slog.Logger) *StreamTracker {
+	return &StreamTracker{
+		storage:    store,
+		subscriber: tonapi.NewSubscriber(tonAPI, log),
+		server:     server,
+		poller:     NewPoller(cfg, store, events, server, log),
+		log:        log,
+	}
+}
+
+// Start streams transactions until ctx is cancelled, syncing the subscribed
+// accounts with wallets in DB on every wallet change and every interval
+func (t *StreamTracker) Start(ctx context.Context, interval time.Duration) {
+	t.log.Info("sse tracker started", "interval", interval)
+
+	if err := t.sync(); err != nil {
+		t.log.Error("sync stream accounts", "error", err)
+	}
+	go t.subscriber.Run(ctx, func(payload tonapi.WebhookPayload) {
+		t.dispatch(ctx, payload)
+	}, t.catchUp)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	changes := t.storage.WalletChanges()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			if err := t.sync(); err != nil {
+				t.log.Error("sync stream accounts", "error", err)
+			}
+		case <-ticker.C:
+			if err := t.sync(); err != nil {
+				t.log.Error("sync stream accounts", "error", err)
+			}
+		}
+	}
+}
+
+func (t *StreamTracker) sync() error {
+	wallets, err := t.storage.GetAllWallets()
+	if err != nil {
+		return err
+	}
+
+	accounts := make([]string, 0, len(wallets))
+	for _, w := range wallets {
+		accounts = append(accounts, w.AddressRaw)
+	}
+	t.subscriber.SetAccounts(accounts)
+
+	metrics.Subscriptions.Set(float64(len(accounts)))
+	return nil
+}
+
+// dispatch queues a streamed transaction, waiting for room instead of dropping it.
+// Returns false if ctx is done first.
+func (t *StreamTracker) dispatch(ctx context.Context, payload tonapi.WebhookPayload) bool {
+	for !t.server.Enqueue(payload) {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+// catchUp queues events missed while a stream was down or being reopened.
+// It runs before the stream delivers again, so it only reads the cursors
+// there: live transactions move them past the gap once processed.
+func (t *StreamTracker) catchUp(ctx context.Context, accounts []string) {
+	cursors := make(map[string]int64, len(accounts))
+	for _, addr := range accounts {
+		cursor, err := t.storage.GetAccountCursor(addr)
+		if err != nil {
+			t.log.Error("get account cursor", "error", err)
+			continue
+		}
+		if cursor > 0 {
+			cursors[addr] = cursor
+		}
+	}
+
+	go t.catchUpFrom(ctx, cursors)
+}
+
+// catchUpFrom queues every completed event after the cursors, waiting for
+// queue room like dispatch, so none is skipped once live events move the cursor
+func (t *StreamTracker) catchUpFrom(ctx context.Context, cursors map[string]int64) {
+	queued := 0
+	for addr, cursor := range cursors {
+		acc := &polledAccount{lastLt: cursor}
+		for {
+			n, err := t.poller.fetch(ctx, addr, acc, func(payload tonapi.WebhookPayload) bool {
+				return t.dispatch(ctx, payload)
+			})
+			queued += n
+			if err != nil {
+				t.log.Warn("catch up account", "address", addr, "error", err)
+			}
+			if err != nil || n < pollMaxEventsOnce {
+				break
+			}
+		}
+	}
+
+	if queued > 0 {
+		t.log.Info("caught up after stream reconnect", "events", queued)
+	}
+}
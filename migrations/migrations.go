@@ -0,0 +1,14 @@
+// Package migrations contains numbered SQL schema migrations.
+//
+// Each database has its own directory (sqlite, postgres) with files named
+// NNNN_description.sql, applied in order by storage. Versions are kept in
+// step across directories. Applied migrations must never be edited, add a
+// new file instead.
+package migrations
+
+import "embed"
+
+// FS holds all migration files
+//
+//go:embed sqlite/*.sql postgres/*.sql
+var FS embed.FS
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS wallets (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	name TEXT NOT NULL,
+	address_raw TEXT NOT NULL,
+	address_display TEXT NOT NULL,
+	min_amount_ton DOUBLE PRECISION,
+	created_at BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_wallets_user_id ON wallets(user_id);
+CREATE INDEX IF NOT EXISTS idx_wallets_address_raw ON wallets(address_raw);
+
+CREATE TABLE IF NOT EXISTS processed_events (
+	wallet_id BIGINT NOT NULL,
+	event_id TEXT NOT NULL,
+	PRIMARY KEY (wallet_id, event_id)
+);
+
+CREATE TABLE IF NOT EXISTS premium_users (
+	user_id BIGINT PRIMARY KEY,
+	activated_at BIGINT NOT NULL,
+	payer_address TEXT,
+	event_id TEXT
+);
+
+CREATE TABLE IF NOT EXISTS premium_payments (
+	event_id TEXT PRIMARY KEY,
+	user_id BIGINT,
+	amount DOUBLE PRECISION,
+	sender_address TEXT
+);
+
+CREATE TABLE IF NOT EXISTS pending_premium_payments (
+	user_id BIGINT PRIMARY KEY,
+	unique_amount DOUBLE PRECISION NOT NULL,
+	created_at BIGINT NOT NULL
+);
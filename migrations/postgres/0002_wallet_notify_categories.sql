@@ -0,0 +1,4 @@
+-- Bit set of storage.NotifyCategory, replaces the per-wallet staking toggle.
+-- New wallets are inserted with storage.NotifyDefault; the default only fills
+-- existing rows and must equal it (checked by TestNotifyCategoriesDefault)
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS notify_categories INTEGER NOT NULL DEFAULT 127;
@@ -0,0 +1,14 @@
+-- Outgoing Telegram notifications, delivered by the outbox worker.
+-- status: pending -> sent, or dead after too many failed attempts
+CREATE TABLE IF NOT EXISTS notification_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	text TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at BIGINT NOT NULL,
+	last_error TEXT,
+	created_at BIGINT NOT NULL,
+	sent_at BIGINT
+);
+CREATE INDEX IF NOT EXISTS idx_notification_outbox_due ON notification_outbox(status, next_attempt_at);
@@ -0,0 +1,12 @@
+-- Premium is bought for a period; reminded_at and downgraded_at are reset on renewal
+ALTER TABLE premium_users ADD COLUMN IF NOT EXISTS expires_at BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE premium_users ADD COLUMN IF NOT EXISTS plan TEXT NOT NULL DEFAULT '';
+ALTER TABLE premium_users ADD COLUMN IF NOT EXISTS reminded_at BIGINT;
+ALTER TABLE premium_users ADD COLUMN IF NOT EXISTS downgraded_at BIGINT;
+
+-- Premium bought before plans existed runs for another 30 days
+UPDATE premium_users SET expires_at = EXTRACT(EPOCH FROM NOW())::BIGINT + 30 * 86400;
+
+-- Wallets over the limit after premium expires are paused instead of deleted
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS paused_at BIGINT;
+CREATE INDEX IF NOT EXISTS idx_premium_users_expires_at ON premium_users(expires_at);
@@ -0,0 +1,3 @@
+-- Premium payment amounts are exact decimal strings, a DOUBLE PRECISION loses
+-- digits of jettons with many decimals
+ALTER TABLE premium_payments ALTER COLUMN amount TYPE TEXT USING amount::TEXT;
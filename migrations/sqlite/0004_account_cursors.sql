@@ -0,0 +1,7 @@
+-- Logical time of the newest processed event per tracked address,
+-- used to backfill events missed while the bot was down
+CREATE TABLE IF NOT EXISTS account_cursors (
+	address_raw TEXT PRIMARY KEY,
+	last_lt INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
@@ -0,0 +1,12 @@
+-- Premium is bought for a period; reminded_at and downgraded_at are reset on renewal
+ALTER TABLE premium_users ADD COLUMN expires_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE premium_users ADD COLUMN plan TEXT NOT NULL DEFAULT '';
+ALTER TABLE premium_users ADD COLUMN reminded_at INTEGER;
+ALTER TABLE premium_users ADD COLUMN downgraded_at INTEGER;
+
+-- Premium bought before plans existed runs for another 30 days
+UPDATE premium_users SET expires_at = CAST(strftime('%s', 'now') AS INTEGER) + 30 * 86400;
+
+-- Wallets over the limit after premium expires are paused instead of deleted
+ALTER TABLE wallets ADD COLUMN paused_at INTEGER;
+CREATE INDEX IF NOT EXISTS idx_premium_users_expires_at ON premium_users(expires_at);
@@ -0,0 +1,28 @@
+-- Premium checkout invoices, matched by memo in the comment or by the exact amount.
+-- status: open -> paid, underpaid (waiting for the rest) or expired
+-- Amounts are minimal units (nanoTON or jetton units) as decimal strings. An amount
+-- stays reserved until the invoice is paid or for a grace period after it expires.
+CREATE TABLE IF NOT EXISTS premium_invoices (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	plan TEXT NOT NULL,
+	currency TEXT NOT NULL,
+	amount TEXT NOT NULL,
+	decimals INTEGER NOT NULL,
+	memo TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'open',
+	paid_amount TEXT NOT NULL DEFAULT '0',
+	amount_reserved INTEGER NOT NULL DEFAULT 1,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	paid_at INTEGER
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_premium_invoices_memo ON premium_invoices(memo);
+-- A reserved amount identifies at most one invoice
+CREATE UNIQUE INDEX IF NOT EXISTS idx_premium_invoices_amount ON premium_invoices(currency, amount) WHERE amount_reserved = 1;
+CREATE INDEX IF NOT EXISTS idx_premium_invoices_user_id ON premium_invoices(user_id, created_at);
+
+-- Payments can be in TON or jettons
+ALTER TABLE premium_payments ADD COLUMN currency TEXT NOT NULL DEFAULT 'TON';
+
+DROP TABLE IF EXISTS pending_premium_payments;
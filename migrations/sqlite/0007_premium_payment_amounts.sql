@@ -0,0 +1,14 @@
+-- Premium payment amounts are exact decimal strings, a REAL loses digits of
+-- jettons with many decimals. SQLite can't change a column type, so the
+-- table is rebuilt.
+CREATE TABLE premium_payments_new (
+	event_id TEXT PRIMARY KEY,
+	user_id INTEGER,
+	amount TEXT,
+	sender_address TEXT,
+	currency TEXT NOT NULL DEFAULT 'TON'
+);
+INSERT INTO premium_payments_new (event_id, user_id, amount, sender_address, currency)
+	SELECT event_id, user_id, CAST(amount AS TEXT), sender_address, currency FROM premium_payments;
+DROP TABLE premium_payments;
+ALTER TABLE premium_payments_new RENAME TO premium_payments;